@@ -0,0 +1,251 @@
+// fakeking is a stand-in for the King that speaks just enough xboard to drive
+// internal/engine without wine. It tracks the game it is sent and, by default,
+// answers go with a post line and the first legal move. A script file can
+// replace that with canned output, errors, hangs and crashes:
+//
+//	# blocks run when a command starting with their trigger arrives
+//	on go
+//	post 1 12 3 101 e5
+//	sleep 50ms
+//	post 2 10 8 102 Nc6
+//	move b8c6
+//
+//	on e2e5
+//	say Illegal move: e2e5
+//
+// Directives are post <depth> <eval> <time> <id> <san>, move [coordinate
+// move], say <raw line>, sleep <duration>, hang (ignore everything but quit)
+// and exit (die without a move). The script path comes from -script or
+// FAKEKING_SCRIPT. Point a worker at it with ENG_CMD=/path/to/fakeking.
+//
+// With -uci it speaks UCI instead, for testing the UCI backend through
+// UCI_ENG_CMD: post arguments are printed after "info" (post depth 3 score cp
+// 20 pv e7e5) and move prints bestmove.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+type script map[string][]string
+
+type fakeKing struct {
+	script script
+	game   *chess.Game
+	hung   bool
+	uci    bool
+}
+
+func main() {
+	scriptPath := flag.String("script", os.Getenv("FAKEKING_SCRIPT"), "path to a fakeking script")
+	uci := flag.Bool("uci", false, "speak UCI instead of xboard")
+	flag.Parse()
+
+	k := &fakeKing{script: script{}, game: chess.NewGame(), uci: *uci}
+	if *scriptPath != "" {
+		s, err := loadScript(*scriptPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		k.script = s
+	}
+
+	s := bufio.NewScanner(os.Stdin)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "quit" {
+			return
+		}
+		if line == "" || k.hung {
+			continue
+		}
+		k.handle(line)
+	}
+}
+
+func loadScript(path string) (script, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read script %s: %w", path, err)
+	}
+
+	s := script{}
+	trigger := "go"
+	for i, raw := range strings.Split(string(b), "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "on ") {
+			trigger = strings.TrimSpace(strings.TrimPrefix(line, "on "))
+			s[trigger] = []string{}
+			continue
+		}
+		if _, _, ok := parseDirective(line); !ok {
+			return nil, fmt.Errorf("%s:%d: unknown directive %q", path, i+1, line)
+		}
+		s[trigger] = append(s[trigger], line)
+	}
+	return s, nil
+}
+
+func parseDirective(line string) (string, string, bool) {
+	name, arg, _ := strings.Cut(line, " ")
+	switch name {
+	case "post", "move", "say", "sleep", "hang", "exit":
+		return name, strings.TrimSpace(arg), true
+	}
+	return "", "", false
+}
+
+func (k *fakeKing) handle(line string) {
+	command := strings.Fields(line)[0]
+
+	if block, ok := k.script[command]; ok {
+		k.run(block)
+		return
+	}
+
+	if k.uci {
+		k.handleUci(command, line)
+		return
+	}
+
+	switch command {
+	case "new":
+		k.game = chess.NewGame()
+	case "setboard":
+		k.setPosition(strings.TrimSpace(strings.TrimPrefix(line, "setboard")))
+	case "go":
+		k.playFirstLegal()
+	case "xboard", "post", "time", "otim", "cm_parm", "easy", "force":
+	default:
+		if err := k.game.PushNotationMove(command, chess.UCINotation{}, nil); err != nil {
+			fmt.Printf("Illegal move: %s\n", command)
+		}
+	}
+}
+
+func (k *fakeKing) handleUci(command, line string) {
+	switch command {
+	case "uci":
+		fmt.Println("id name fakeking")
+		fmt.Println("option name Skill Level type spin default 20 min 0 max 20")
+		fmt.Println("option name UCI_Elo type spin default 1350 min 1350 max 2850")
+		fmt.Println("uciok")
+	case "isready":
+		fmt.Println("readyok")
+	case "ucinewgame":
+		k.game = chess.NewGame()
+	case "position":
+		position, moves, _ := strings.Cut(line, " moves ")
+		fen, _ := strings.CutPrefix(position, "position fen ")
+		if fen == position {
+			fen = ""
+		}
+		if !k.setPosition(fen) {
+			return
+		}
+		for _, move := range strings.Fields(moves) {
+			if err := k.game.PushNotationMove(move, chess.UCINotation{}, nil); err != nil {
+				fmt.Printf("info string illegal move %s\n", move)
+				return
+			}
+		}
+	case "go":
+		k.playFirstLegal()
+	}
+}
+
+// setPosition starts a new game from fen, or the initial position when it is
+// empty.
+func (k *fakeKing) setPosition(fen string) bool {
+	if fen == "" {
+		k.game = chess.NewGame()
+		return true
+	}
+	fenOpt, err := chess.FEN(fen)
+	if err != nil {
+		if k.uci {
+			fmt.Printf("info string bad fen %s\n", fen)
+		} else {
+			fmt.Printf("Error (bad fen): %s\n", fen)
+		}
+		return false
+	}
+	k.game = chess.NewGame(fenOpt)
+	return true
+}
+
+func (k *fakeKing) run(block []string) {
+	for _, line := range block {
+		name, arg, _ := parseDirective(line)
+		switch name {
+		case "post":
+			if k.uci {
+				fmt.Println("info " + arg)
+				continue
+			}
+			fmt.Println(arg)
+		case "say":
+			fmt.Println(arg)
+		case "move":
+			if arg == "" {
+				k.playFirstLegal()
+				continue
+			}
+			k.game.PushNotationMove(arg, chess.UCINotation{}, nil)
+			k.printMove(arg)
+		case "sleep":
+			d, err := time.ParseDuration(arg)
+			if err == nil {
+				time.Sleep(d)
+			}
+		case "hang":
+			k.hung = true
+			return
+		case "exit":
+			os.Exit(1)
+		}
+	}
+}
+
+func (k *fakeKing) playFirstLegal() {
+	validMoves := k.game.ValidMoves()
+	if len(validMoves) == 0 {
+		if k.uci {
+			fmt.Println("bestmove (none)")
+			return
+		}
+		fmt.Println("Error (no legal moves): go")
+		return
+	}
+	pos := k.game.Position()
+	m := validMoves[0]
+	san := chess.AlgebraicNotation{}.Encode(pos, &m)
+	uci := chess.UCINotation{}.Encode(pos, &m)
+
+	if k.uci {
+		fmt.Printf("info depth 1 score cp 0 time 0 pv %s\n", uci)
+	} else {
+		fmt.Printf("1 0 0 1 %s\n", san)
+	}
+	k.game.Move(&m, nil)
+	k.printMove(uci)
+}
+
+func (k *fakeKing) printMove(move string) {
+	if k.uci {
+		fmt.Printf("bestmove %s\n", move)
+		return
+	}
+	fmt.Printf("move %s\n", move)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	chess "github.com/corentings/chess/v2"
+	"github.com/thinktt/yowking/internal/books"
+)
+
+const (
+	bookConvertUsage  = "usage: kingctl book convert <book.obk> [book.bin]"
+	bookMergeUsage    = "usage: kingctl book merge -o <out.bin> [-min-weight <w>] [-max-ply <n>] <book.bin[:multiplier]>..."
+	bookFilterUsage   = "usage: kingctl book filter [-min-weight <w>] [-max-ply <n>] <in.bin> <out.bin>"
+	bookReweightUsage = "usage: kingctl book reweight -scale <f> [-min-weight <w>] [-max-ply <n>] <in.bin> <out.bin>"
+	bookFromPGNUsage  = "usage: kingctl book frompgn -o <out.bin> [-player <name>] [-side <white|black>] [-max-ply <n>] [-win <w>] [-draw <w>] [-loss <w>] [-min-weight <w>] <games.pgn>..."
+)
+
+// runBookConvert writes a Chessmaster .obk book as a polyglot .bin book,
+// next to it when no output is named. Paths are relative to the working
+// directory, not the binary.
+func runBookConvert(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New(bookConvertUsage)
+	}
+	obkPath := args[0]
+	binPath := strings.TrimSuffix(obkPath, filepath.Ext(obkPath)) + ".bin"
+	if len(args) == 2 {
+		binPath = args[1]
+	}
+
+	count, err := books.ConvertOBK(obkPath, binPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d moves to %s\n", count, binPath)
+	return nil
+}
+
+// bookEdit is what merge, filter and reweight do once their books are read:
+// drop light moves, cap the depth and write the result.
+type bookEdit struct {
+	minWeight uint
+	maxPly    int
+}
+
+func (e *bookEdit) addFlags(flags *flag.FlagSet) {
+	flags.UintVar(&e.minWeight, "min-weight", 0, "drop moves weighing less than this")
+	flags.IntVar(&e.maxPly, "max-ply", 0, "drop moves more than this many plies from the initial position, 0 for no limit")
+}
+
+func (e *bookEdit) run(sources []books.MergeSource, outPath string) error {
+	if e.minWeight > 65535 {
+		return fmt.Errorf("min-weight %d is more than a polyglot weight can be", e.minWeight)
+	}
+	if e.maxPly < 0 {
+		return fmt.Errorf("max-ply must not be negative, got %d", e.maxPly)
+	}
+
+	entries, err := books.MergeBooks(sources)
+	if err != nil {
+		return err
+	}
+	entries = books.DropLightMoves(entries, uint16(e.minWeight))
+	if e.maxPly > 0 {
+		entries, err = books.LimitPly(entries, e.maxPly)
+		if err != nil {
+			return err
+		}
+	}
+	if err := books.WritePolyglotFile(outPath, "", entries); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d moves to %s\n", len(entries), outPath)
+	return nil
+}
+
+// runBookMerge adds up the moves of several books, each weight times its
+// book's multiplier.
+func runBookMerge(args []string) error {
+	flags := flag.NewFlagSet("book merge", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	var edit bookEdit
+	edit.addFlags(flags)
+	var outPath string
+	flags.StringVar(&outPath, "o", "", "book to write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if outPath == "" || flags.NArg() == 0 {
+		return errors.New(bookMergeUsage)
+	}
+
+	sources := make([]books.MergeSource, 0, flags.NArg())
+	for _, arg := range flags.Args() {
+		source, err := parseMergeSource(arg)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+	return edit.run(sources, outPath)
+}
+
+// parseMergeSource reads book.bin or book.bin:multiplier.
+func parseMergeSource(arg string) (books.MergeSource, error) {
+	i := strings.LastIndex(arg, ":")
+	if i < 0 {
+		return books.MergeSource{Path: arg, Multiplier: 1}, nil
+	}
+	multiplier, err := strconv.ParseFloat(arg[i+1:], 64)
+	if err != nil || multiplier < 0 {
+		return books.MergeSource{}, fmt.Errorf("bad multiplier in %q, want book.bin:<number>", arg)
+	}
+	return books.MergeSource{Path: arg[:i], Multiplier: multiplier}, nil
+}
+
+// runBookFilter writes the moves of a book that pass -min-weight and
+// -max-ply.
+func runBookFilter(args []string) error {
+	flags := flag.NewFlagSet("book filter", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	var edit bookEdit
+	edit.addFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New(bookFilterUsage)
+	}
+	return edit.run([]books.MergeSource{{Path: flags.Arg(0), Multiplier: 1}}, flags.Arg(1))
+}
+
+// runBookReweight writes a book with every weight times -scale, for
+// weighting a book before it is merged or played.
+func runBookReweight(args []string) error {
+	flags := flag.NewFlagSet("book reweight", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	var edit bookEdit
+	edit.addFlags(flags)
+	scale := flags.Float64("scale", 0, "multiply every weight by this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 || *scale <= 0 {
+		return errors.New(bookReweightUsage)
+	}
+	return edit.run([]books.MergeSource{{Path: flags.Arg(0), Multiplier: *scale}}, flags.Arg(1))
+}
+
+// runBookFromPGN builds a book from PGN collections, for a personality that
+// plays like the games in them.
+func runBookFromPGN(args []string) error {
+	flags := flag.NewFlagSet("book frompgn", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	var outPath, side string
+	var minWeight uint
+	opts := books.PGNOptions{}
+	flags.StringVar(&outPath, "o", "", "book to write")
+	flags.StringVar(&opts.Player, "player", "", "only games this player played, and only their moves")
+	flags.StringVar(&side, "side", "", "only white's or black's moves")
+	flags.IntVar(&opts.MaxPly, "max-ply", 20, "plies of each game to read")
+	flags.UintVar(&opts.Win, "win", 2, "weight added for a move in a game its side won")
+	flags.UintVar(&opts.Draw, "draw", 1, "weight added for a move in a drawn or unfinished game")
+	flags.UintVar(&opts.Loss, "loss", 0, "weight added for a move in a game its side lost")
+	flags.UintVar(&minWeight, "min-weight", 0, "drop moves weighing less than this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if outPath == "" || flags.NArg() == 0 {
+		return errors.New(bookFromPGNUsage)
+	}
+	switch strings.ToLower(side) {
+	case "":
+		opts.Side = chess.NoColor
+	case "white":
+		opts.Side = chess.White
+	case "black":
+		opts.Side = chess.Black
+	default:
+		return fmt.Errorf("side must be white or black, got %q", side)
+	}
+	if opts.MaxPly <= 0 {
+		return fmt.Errorf("max-ply must be positive, got %d", opts.MaxPly)
+	}
+	if minWeight > 65535 {
+		return fmt.Errorf("min-weight %d is more than a polyglot weight can be", minWeight)
+	}
+
+	// every file is read before any weights are summed, so a move played in
+	// several files gets one entry
+	var pgn []io.Reader
+	for _, pgnPath := range flags.Args() {
+		f, err := os.Open(pgnPath)
+		if err != nil {
+			return fmt.Errorf("open pgn %q: %w", pgnPath, err)
+		}
+		defer f.Close()
+		// a newline between files keeps the last game of one apart from the
+		// first of the next
+		pgn = append(pgn, f, strings.NewReader("\n\n"))
+	}
+
+	entries, stats, err := books.BookFromPGN(io.MultiReader(pgn...), opts)
+	if err != nil {
+		return err
+	}
+	entries = books.DropLightMoves(entries, uint16(minWeight))
+	if err := books.WritePolyglotFile(outPath, "", entries); err != nil {
+		return err
+	}
+	fmt.Printf("read %d games, used %d, skipped %d unreadable\n", stats.Games, stats.Used, stats.Skipped)
+	fmt.Printf("wrote %d moves to %s\n", len(entries), outPath)
+	return nil
+}
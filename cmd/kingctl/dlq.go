@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/thinktt/yowking/internal/dlq"
+	"github.com/thinktt/yowking/internal/engine"
+	"github.com/thinktt/yowking/internal/moves"
+	"github.com/thinktt/yowking/pkg/models"
+)
+
+const dlqUsage = "usage: kingctl dlq <list | show <seq> | replay [--publish] [--delete] <seq>>"
+
+// runDlqCommand inspects and replays the move-req-dlq stream, connecting
+// with NATS_URL and NATS_TOKEN like kingworker.
+func runDlqCommand(commandArgs []string) error {
+	if len(commandArgs) == 0 {
+		return errors.New(dlqUsage)
+	}
+
+	nc, err := connectNats()
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		return fmt.Errorf("create JetStream context: %w", err)
+	}
+
+	switch commandArgs[0] {
+	case "list":
+		return listDlq(js)
+	case "show":
+		seq, err := parseDlqSeq(commandArgs[1:])
+		if err != nil {
+			return err
+		}
+		return showDlq(js, seq)
+	case "replay":
+		return replayDlq(js, commandArgs[1:])
+	default:
+		return errors.New(dlqUsage)
+	}
+}
+
+func connectNats() (*nats.Conn, error) {
+	natsUrl := os.Getenv("NATS_URL")
+	if natsUrl == "" {
+		natsUrl = nats.DefaultURL
+	}
+	nc, err := nats.Connect(natsUrl, nats.Token(os.Getenv("NATS_TOKEN")))
+	if err != nil {
+		return nil, fmt.Errorf("connect to NATS at %s: %w", natsUrl, err)
+	}
+	return nc, nil
+}
+
+func parseDlqSeq(args []string) (uint64, error) {
+	if len(args) != 1 {
+		return 0, errors.New(dlqUsage)
+	}
+	seq, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad seq %q: %w", args[0], err)
+	}
+	return seq, nil
+}
+
+func listDlq(js nats.JetStreamContext) error {
+	entries, err := dlq.List(js)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-6s %-20s %-8s %-20s %-15s %s\n", "SEQ", "FAILED", "ATTEMPTS", "CODE", "GAME", "MESSAGE")
+	for _, entry := range entries {
+		code, message := "", ""
+		if entry.Error != nil {
+			code, message = entry.Error.Code, entry.Error.Message
+		}
+		var moveReq models.MoveReq
+		json.Unmarshal(entry.Payload, &moveReq)
+		fmt.Printf("%-6d %-20s %-8d %-20s %-15s %s\n",
+			entry.Seq, entry.FailedAt.Format("2006-01-02 15:04:05"), entry.Attempts, code, moveReq.GameId, message)
+	}
+	return nil
+}
+
+// showDlq prints a record, with the payload inlined when it is valid JSON.
+func showDlq(js nats.JetStreamContext, seq uint64) error {
+	entry, err := dlq.Get(js, seq)
+	if err != nil {
+		return err
+	}
+
+	var payload any = string(entry.Payload)
+	if json.Valid(entry.Payload) {
+		payload = json.RawMessage(entry.Payload)
+	}
+	return writeJSON(struct {
+		dlq.Entry
+		Payload any `json:"payload"`
+	}{entry, payload})
+}
+
+// replayDlq runs a dead-lettered request through moves.HandleMoveReq here,
+// or with --publish sends it back to move-req for the workers. --delete drops
+// it from the dead-letter stream once it has been replayed.
+func replayDlq(js nats.JetStreamContext, args []string) error {
+	replayFlags := flag.NewFlagSet("dlq replay", flag.ContinueOnError)
+	replayFlags.SetOutput(os.Stderr)
+	publish := replayFlags.Bool("publish", false, "send the request back to move-req instead of playing it locally")
+	deleteAfter := replayFlags.Bool("delete", false, "remove the record from the dead-letter stream after replaying it")
+	if err := replayFlags.Parse(args); err != nil {
+		return err
+	}
+	seq, err := parseDlqSeq(replayFlags.Args())
+	if err != nil {
+		return err
+	}
+
+	entry, err := dlq.Get(js, seq)
+	if err != nil {
+		return err
+	}
+
+	if *publish {
+		ack, err := js.Publish("move-req", entry.Payload)
+		if err != nil {
+			return fmt.Errorf("publish to move-req: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "republished as move-req-stream seq %d\n", ack.Sequence)
+	} else if err := replayLocally(entry.Payload); err != nil {
+		return err
+	}
+
+	if *deleteAfter {
+		if err := dlq.Delete(js, seq); err != nil {
+			return fmt.Errorf("delete %s seq %d: %w", dlq.StreamName, seq, err)
+		}
+	}
+	return nil
+}
+
+func replayLocally(payload []byte) error {
+	var moveReq models.MoveReq
+	if err := json.Unmarshal(payload, &moveReq); err != nil {
+		return fmt.Errorf("parse move request json: %w", err)
+	}
+
+	binaryDirectoryPath, err := binaryDir()
+	if err != nil {
+		return err
+	}
+	if err := prepareLocalRuntime(binaryDirectoryPath); err != nil {
+		return err
+	}
+
+	defer engine.Shutdown()
+	moveResponse, err := moves.HandleMoveReq(context.Background(), moveReq)
+	if err != nil {
+		return fmt.Errorf("handle move request: %w", err)
+	}
+	return writeJSON(moveResponse)
+}
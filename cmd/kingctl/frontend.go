@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/thinktt/yowking/internal/moves"
+	"github.com/thinktt/yowking/pkg/models"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+// personalityNames lists the loaded personalities for GUI option lists.
+func personalityNames() []string {
+	names := make([]string, 0, len(personalities.CmpMap))
+	for name := range personalities.CmpMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newGameId tags front end games so their engine logs can be told apart. A
+// one letter prefix keeps the id within the 15 characters a gameId allows.
+func newGameId(prefix string) string {
+	return prefix + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// playMove runs a front end's move request through the production path,
+// folding an error reported in the move data into err.
+func playMove(ctx context.Context, moveReq models.MoveReq) (models.MoveData, error) {
+	moveData, err := moves.HandleMoveReq(ctx, moveReq)
+	if err != nil {
+		return models.MoveData{}, err
+	}
+	if moveData.Err != nil {
+		return models.MoveData{}, errors.New(*moveData.Err)
+	}
+	return moveData, nil
+}
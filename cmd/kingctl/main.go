@@ -1,15 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/thinktt/yowking/internal/booktester"
+	"github.com/thinktt/yowking/internal/engine"
 	"github.com/thinktt/yowking/internal/moves"
 	"github.com/thinktt/yowking/pkg/models"
 	"github.com/thinktt/yowking/pkg/personalities"
@@ -37,6 +38,21 @@ func main() {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
+	case "uci":
+		if err := runUciCommand(commandArgs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "xboard":
+		if err := runXboardCommand(commandArgs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "dlq":
+		if err := runDlqCommand(commandArgs); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", command)
 		printUsage()
@@ -50,16 +66,31 @@ func printUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  kingctl move <json>")
 	fmt.Println("  kingctl book <fens|mem>")
+	fmt.Println("  kingctl book convert <book.obk> [book.bin]")
+	fmt.Println("  kingctl book <merge|filter|reweight> [flags] <books...>")
+	fmt.Println("  kingctl book frompgn -o <out.bin> [flags] <games.pgn>...")
+	fmt.Println("  kingctl uci [--cmp <name>]")
+	fmt.Println("  kingctl xboard [--cmp <name>]")
+	fmt.Println("  kingctl dlq <list|show <seq>|replay [--publish] [--delete] <seq>>")
 	fmt.Println("")
 	fmt.Println("Commands:")
 	fmt.Println("  move    Run move resolution directly (book + engine), no NATS")
-	fmt.Println("  book    Run book tests/memory checks")
+	fmt.Println("  book    Run book tests/memory checks, convert Chessmaster books, build custom books")
+	fmt.Println("  uci     Play a personality as a UCI engine on stdin/stdout")
+	fmt.Println("  xboard  Play a personality as an xboard/CECP engine on stdin/stdout")
+	fmt.Println("  dlq     Inspect and replay dead-lettered move requests (uses NATS_URL, NATS_TOKEN)")
 	fmt.Println("")
 	fmt.Println("Examples:")
 	fmt.Println(`  kingctl move '{"cmpName":"Wizard","gameId":"g1","moves":["e2e4"]}'`)
 	fmt.Println(`  kingctl move --skip-book '{"cmpName":"Wizard","gameId":"g1","moves":["e2e4"]}'`)
 	fmt.Println(`  kingctl book fens`)
 	fmt.Println(`  kingctl book mem`)
+	fmt.Println(`  kingctl book convert Strong.obk books/Strong.bin`)
+	fmt.Println(`  kingctl book merge -o books/Custom.bin -max-ply 16 books/Strong.bin books/Gm.bin:2`)
+	fmt.Println(`  kingctl book frompgn -o books/Tal.bin -player Tal -max-ply 24 tal.pgn`)
+	fmt.Println(`  kingctl uci --cmp Wizard`)
+	fmt.Println(`  kingctl xboard --cmp Wizard`)
+	fmt.Println(`  kingctl dlq replay --publish --delete 3`)
 }
 
 func runMoveCommand(commandArgs []string) error {
@@ -82,7 +113,8 @@ func runMoveCommand(commandArgs []string) error {
 		return err
 	}
 
-	moveResponse, err := moves.HandleMoveReq(moveReq)
+	defer engine.Shutdown()
+	moveResponse, err := moves.HandleMoveReq(context.Background(), moveReq)
 	if err != nil {
 		return err
 	}
@@ -120,7 +152,7 @@ func applyMoveRequestDefaults(moveReq models.MoveReq, skipBookLookup bool) model
 	}
 	gameIDMissing := moveReq.GameId == ""
 	if gameIDMissing {
-		moveReq.GameId = fmt.Sprintf("kingctl-%d", time.Now().UnixNano())
+		moveReq.GameId = newGameId("k")
 	}
 	return moveReq
 }
@@ -134,6 +166,21 @@ func prepareLocalRuntime(binaryDirectoryPath string) error {
 }
 
 func runBookCommand(commandArgs []string) error {
+	if len(commandArgs) > 0 {
+		switch commandArgs[0] {
+		case "convert":
+			return runBookConvert(commandArgs[1:])
+		case "merge":
+			return runBookMerge(commandArgs[1:])
+		case "filter":
+			return runBookFilter(commandArgs[1:])
+		case "reweight":
+			return runBookReweight(commandArgs[1:])
+		case "frompgn":
+			return runBookFromPGN(commandArgs[1:])
+		}
+	}
+
 	bookSubcommand, err := parseBookCommandArgs(commandArgs)
 	if err != nil {
 		return err
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/thinktt/yowking/internal/engine"
+	"github.com/thinktt/yowking/pkg/models"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+// runUciCommand speaks UCI on stdin/stdout so a personality can be loaded
+// into a chess GUI or cutechess-cli. Every go runs through
+// moves.HandleMoveReq, so books, randomness and calibrated clock times match
+// production; the GUI's own time controls are ignored.
+func runUciCommand(commandArgs []string) error {
+	uciFlags := flag.NewFlagSet("uci", flag.ContinueOnError)
+	uciFlags.SetOutput(os.Stderr)
+	cmpName := uciFlags.String("cmp", "", "personality to play as")
+	if err := uciFlags.Parse(commandArgs); err != nil {
+		return err
+	}
+
+	binaryDirectoryPath, err := binaryDir()
+	if err != nil {
+		return err
+	}
+	if err := prepareLocalRuntime(binaryDirectoryPath); err != nil {
+		return err
+	}
+	defer engine.Shutdown()
+
+	names := personalityNames()
+	if len(names) == 0 {
+		return errors.New("no personalities loaded")
+	}
+	if *cmpName == "" {
+		*cmpName = names[0]
+	}
+	if _, ok := personalities.CmpMap[*cmpName]; !ok {
+		return fmt.Errorf("%s is not a valid personality", *cmpName)
+	}
+
+	session := newUciSession(*cmpName, os.Stdout)
+	session.run(os.Stdin)
+	return nil
+}
+
+type uciSession struct {
+	out      io.Writer
+	outMu    sync.Mutex
+	cmpName  string
+	useBook  bool
+	gameId   string
+	startFen string
+	moves    []string
+	cancel   context.CancelCauseFunc
+	searches sync.WaitGroup
+}
+
+func newUciSession(cmpName string, out io.Writer) *uciSession {
+	return &uciSession{
+		out:     out,
+		cmpName: cmpName,
+		useBook: true,
+		gameId:  newGameId("u"),
+	}
+}
+
+func (s *uciSession) send(format string, args ...any) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	fmt.Fprintf(s.out, format+"\n", args...)
+}
+
+func (s *uciSession) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "quit" {
+			break
+		}
+		s.handle(line)
+	}
+
+	if s.cancel != nil {
+		s.cancel(nil)
+	}
+	s.searches.Wait()
+}
+
+func (s *uciSession) handle(line string) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "uci":
+		s.send("id name yowking %s", s.cmpName)
+		s.send("id author thinktt")
+		s.send("option name Personality type combo default %s%s", s.cmpName, comboVars(personalityNames()))
+		s.send("option name OwnBook type check default true")
+		s.send("uciok")
+	case "isready":
+		s.send("readyok")
+	case "setoption":
+		s.setOption(line)
+	case "ucinewgame":
+		s.searches.Wait()
+		s.gameId = newGameId("u")
+		s.startFen = ""
+		s.moves = nil
+	case "position":
+		s.searches.Wait()
+		s.setPosition(fields[1:])
+	case "go":
+		s.searches.Wait()
+		s.goSearch()
+	case "stop":
+		// the search answers with the engine's best move so far, a search
+		// that already ended ignores it
+		if s.cancel != nil {
+			s.cancel(engine.ErrStopped)
+		}
+	}
+}
+
+func comboVars(names []string) string {
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(" var ")
+		b.WriteString(name)
+	}
+	return b.String()
+}
+
+// setOption handles "setoption name <name> value <value>".
+func (s *uciSession) setOption(line string) {
+	rest, ok := strings.CutPrefix(line, "setoption name ")
+	if !ok {
+		return
+	}
+	name, value, _ := strings.Cut(rest, " value ")
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+
+	switch strings.ToLower(name) {
+	case "personality":
+		if _, ok := personalities.CmpMap[value]; !ok {
+			s.send("info string %s is not a valid personality", value)
+			return
+		}
+		s.cmpName = value
+	case "ownbook":
+		s.useBook = !strings.EqualFold(value, "false")
+	}
+}
+
+// setPosition handles "position startpos [moves ...]" and
+// "position fen <fen> [moves ...]".
+func (s *uciSession) setPosition(args []string) {
+	s.startFen = ""
+	s.moves = nil
+	if len(args) == 0 {
+		return
+	}
+
+	rest := args[1:]
+	switch args[0] {
+	case "startpos":
+	case "fen":
+		fenEnd := slices.Index(rest, "moves")
+		if fenEnd < 0 {
+			fenEnd = len(rest)
+		}
+		s.startFen = strings.Join(rest[:fenEnd], " ")
+		rest = rest[fenEnd:]
+	default:
+		s.send("info string unknown position %s", args[0])
+		return
+	}
+
+	if len(rest) > 1 && rest[0] == "moves" {
+		s.moves = append([]string{}, rest[1:]...)
+	}
+}
+
+func (s *uciSession) goSearch() {
+	moveReq := models.MoveReq{
+		Moves:          append([]string{}, s.moves...),
+		StartFen:       s.startFen,
+		CmpName:        s.cmpName,
+		GameId:         s.gameId,
+		ShouldSkipBook: !s.useBook,
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	s.cancel = cancel
+	s.searches.Add(1)
+	go func() {
+		defer s.searches.Done()
+		defer cancel(nil)
+
+		moveData, err := playMove(ctx, moveReq)
+		if err != nil {
+			s.send("info string %s", err)
+			s.send("bestmove 0000")
+			return
+		}
+
+		if moveData.Type == "engine" {
+			s.send("info depth %d score cp %d time %d pv %s", moveData.Depth, moveData.Eval, moveData.Time*10, moveData.CoordinateMove)
+		} else {
+			s.send("info string %s move", moveData.Type)
+		}
+		s.send("bestmove %s", moveData.CoordinateMove)
+	}()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	chess "github.com/corentings/chess/v2"
+	"github.com/thinktt/yowking/internal/books"
+	"github.com/thinktt/yowking/internal/engine"
+	"github.com/thinktt/yowking/pkg/models"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+// runXboardCommand speaks CECP (xboard/WinBoard protocol 2) on stdin/stdout.
+// Moves go through moves.HandleMoveReq, so the personality's polyglot book is
+// consulted before the King is asked, just like in production.
+func runXboardCommand(commandArgs []string) error {
+	xboardFlags := flag.NewFlagSet("xboard", flag.ContinueOnError)
+	xboardFlags.SetOutput(os.Stderr)
+	cmpName := xboardFlags.String("cmp", "", "personality to play as")
+	if err := xboardFlags.Parse(commandArgs); err != nil {
+		return err
+	}
+
+	binaryDirectoryPath, err := binaryDir()
+	if err != nil {
+		return err
+	}
+	if err := prepareLocalRuntime(binaryDirectoryPath); err != nil {
+		return err
+	}
+	defer engine.Shutdown()
+
+	names := personalityNames()
+	if len(names) == 0 {
+		return errors.New("no personalities loaded")
+	}
+	if *cmpName == "" {
+		*cmpName = names[0]
+	}
+	if _, ok := personalities.CmpMap[*cmpName]; !ok {
+		return fmt.Errorf("%s is not a valid personality", *cmpName)
+	}
+
+	session := newXboardSession(*cmpName, os.Stdout)
+	session.run(os.Stdin)
+	return nil
+}
+
+type xboardSession struct {
+	out     io.Writer
+	outMu   sync.Mutex
+	cmpName string
+	useBook bool
+	gameId  string
+	// startFen is the setboard position, empty for the initial position
+	startFen string
+	moves    []string
+	// game is the position after startFen and moves
+	game *chess.Game
+	// force is xboard's force mode: record moves, never reply
+	force bool
+	// engineColor is the side the engine plays
+	engineColor chess.Color
+	cancel      context.CancelFunc
+	searches    sync.WaitGroup
+}
+
+func newXboardSession(cmpName string, out io.Writer) *xboardSession {
+	return &xboardSession{
+		out:         out,
+		cmpName:     cmpName,
+		useBook:     true,
+		gameId:      newGameId("x"),
+		game:        chess.NewGame(),
+		engineColor: chess.Black,
+	}
+}
+
+func (s *xboardSession) send(format string, args ...any) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	fmt.Fprintf(s.out, format+"\n", args...)
+}
+
+func (s *xboardSession) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "quit" {
+			break
+		}
+		s.handle(line)
+	}
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.searches.Wait()
+}
+
+func (s *xboardSession) handle(line string) {
+	command, arg, _ := strings.Cut(line, " ")
+	switch command {
+	case "protover":
+		s.send(`feature myname="yowking %s" usermove=1 setboard=1 ping=1 san=0 colors=0 sigint=0 sigterm=0 reuse=1 analyze=0 done=0`, s.cmpName)
+		s.send(`feature option="Personality -combo %s"`, comboOptions(personalityNames(), s.cmpName))
+		s.send(`feature option="OwnBook -check 1"`)
+		s.send("feature done=1")
+	case "new":
+		s.searches.Wait()
+		s.gameId = newGameId("x")
+		s.startFen = ""
+		s.moves = nil
+		s.game = chess.NewGame()
+		s.force = false
+		s.engineColor = chess.Black
+	case "setboard":
+		s.searches.Wait()
+		s.setBoard(strings.TrimSpace(arg))
+	case "force":
+		s.force = true
+	case "go":
+		s.searches.Wait()
+		s.force = false
+		s.engineColor = s.sideToMove()
+		s.goSearch()
+	case "playother":
+		s.searches.Wait()
+		s.force = false
+		s.engineColor = s.sideToMove().Other()
+	case "usermove":
+		s.searches.Wait()
+		s.userMove(strings.TrimSpace(arg))
+	case "undo":
+		s.searches.Wait()
+		s.takeBack(1)
+	case "remove":
+		s.searches.Wait()
+		s.takeBack(2)
+	case "ping":
+		s.searches.Wait()
+		s.send("pong %s", arg)
+	case "option":
+		s.setOption(arg)
+	}
+}
+
+// comboOptions renders a CECP combo, marking the default with *.
+func comboOptions(names []string, defaultName string) string {
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		if name == defaultName {
+			name = "*" + name
+		}
+		parts = append(parts, name)
+	}
+	return strings.Join(parts, " /// ")
+}
+
+// setOption handles "option <name>=<value>".
+func (s *xboardSession) setOption(arg string) {
+	name, value, _ := strings.Cut(arg, "=")
+	switch name {
+	case "Personality":
+		if _, ok := personalities.CmpMap[value]; !ok {
+			s.send("tellusererror %s is not a valid personality", value)
+			return
+		}
+		s.cmpName = value
+	case "OwnBook":
+		s.useBook = value != "0"
+	}
+}
+
+// setBoard handles "setboard <fen>", which starts a new game from the
+// position.
+func (s *xboardSession) setBoard(fen string) {
+	g, err := books.PlayMoves(fen, nil)
+	if err != nil {
+		s.send("tellusererror Illegal position")
+		return
+	}
+	s.gameId = newGameId("x")
+	s.startFen = fen
+	s.moves = nil
+	s.game = g
+}
+
+// sideToMove is the color to play after the recorded moves.
+func (s *xboardSession) sideToMove() chess.Color {
+	return s.game.Position().Turn()
+}
+
+// userMove plays a move from the GUI, which sends UCI style coordinates
+// with usermove=1 and san=0.
+func (s *xboardSession) userMove(move string) {
+	if err := s.game.PushNotationMove(move, chess.UCINotation{}, nil); err != nil {
+		s.send("Illegal move: %s", move)
+		return
+	}
+	s.moves = append(s.moves, move)
+
+	if !s.force && s.sideToMove() == s.engineColor {
+		s.goSearch()
+	}
+}
+
+func (s *xboardSession) takeBack(plies int) {
+	if plies > len(s.moves) {
+		plies = len(s.moves)
+	}
+	s.moves = s.moves[:len(s.moves)-plies]
+
+	// every move left was legal when it was played
+	g, err := books.PlayMoves(s.startFen, s.moves)
+	if err != nil {
+		s.send("tellusererror %s", err)
+		return
+	}
+	s.game = g
+}
+
+func (s *xboardSession) goSearch() {
+	moveReq := models.MoveReq{
+		Moves:          append([]string{}, s.moves...),
+		StartFen:       s.startFen,
+		CmpName:        s.cmpName,
+		GameId:         s.gameId,
+		ShouldSkipBook: !s.useBook,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.searches.Add(1)
+	go func() {
+		defer s.searches.Done()
+		defer cancel()
+
+		moveData, err := playMove(ctx, moveReq)
+		if err != nil {
+			s.send("tellusererror %s", err)
+			return
+		}
+
+		if moveData.Type == "engine" {
+			s.send("%d %d %d 0 %s", moveData.Depth, moveData.Eval, moveData.Time, moveData.CoordinateMove)
+		}
+		if err := s.game.PushNotationMove(moveData.CoordinateMove, chess.UCINotation{}, nil); err != nil {
+			s.send("tellusererror engine move %s: %s", moveData.CoordinateMove, err)
+			return
+		}
+		s.moves = append(s.moves, moveData.CoordinateMove)
+		s.send("move %s", moveData.CoordinateMove)
+	}()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/thinktt/yowking/internal/engine"
+	"github.com/thinktt/yowking/internal/envconf"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+// minMoveTimeout is the least a move request is given, for personalities
+// calibrated to short clocks.
+const minMoveTimeout = 30 * time.Second
+
+// config is kinghttp's tuning, read from the environment.
+type config struct {
+	// addr is where the API is served (HTTP_ADDR, default :8080).
+	addr string
+	// concurrency is how many move requests are worked on at once
+	// (WORKER_CONCURRENCY, default 1). The engine pool grows to match.
+	concurrency int
+	// queueTimeout is how long a move request waits for a free slot before
+	// it is turned away with a 503 (QUEUE_TIMEOUT, default 10s).
+	queueTimeout time.Duration
+	// moveTimeout bounds a move request once it has a slot (MOVE_TIMEOUT).
+	// The default lets the slowest calibrated personality finish its move.
+	moveTimeout time.Duration
+	// shutdownGrace is how long requests in progress get to finish after
+	// SIGTERM (SHUTDOWN_GRACE, default 20s).
+	shutdownGrace time.Duration
+}
+
+func configFromEnv() (config, error) {
+	addr := os.Getenv("HTTP_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	concurrency, err := envconf.Positive("WORKER_CONCURRENCY", 1)
+	if err != nil {
+		return config{}, err
+	}
+	queueTimeout, err := envconf.Duration("QUEUE_TIMEOUT", 10*time.Second)
+	if err != nil {
+		return config{}, err
+	}
+	moveTimeout, err := envconf.Duration("MOVE_TIMEOUT", max(engine.MoveTimeout(personalities.MaxClockTime()), minMoveTimeout))
+	if err != nil {
+		return config{}, err
+	}
+	shutdownGrace, err := envconf.Duration("SHUTDOWN_GRACE", 20*time.Second)
+	if err != nil {
+		return config{}, err
+	}
+
+	return config{
+		addr:          addr,
+		concurrency:   int(concurrency),
+		queueTimeout:  queueTimeout,
+		moveTimeout:   moveTimeout,
+		shutdownGrace: shutdownGrace,
+	}, nil
+}
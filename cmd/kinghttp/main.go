@@ -0,0 +1,53 @@
+// kinghttp answers move requests over plain HTTP/JSON, for scripts and
+// deployments without a NATS broker. It runs from the dist directory like
+// kingworker and plays through the same moves.HandleMoveReq.
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thinktt/yowking/internal/engine"
+	"github.com/thinktt/yowking/internal/moves"
+)
+
+var log = logrus.New()
+
+func main() {
+	cfg, err := configFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("working on", cfg.concurrency, "move requests at a time, move timeout", cfg.moveTimeout)
+
+	moves.Start(cfg.concurrency)
+
+	srv := newServer(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	go func() {
+		log.Println("serving move requests on", cfg.addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Error serving HTTP: %v", err)
+		}
+	}()
+	<-ctx.Done()
+
+	log.Println("waiting up to", cfg.shutdownGrace, "for move requests in progress")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownGrace)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Warnf("shutdown grace period is over, closing connections: %v", err)
+		srv.Close()
+	}
+
+	log.Println("shutting down engines")
+	engine.Shutdown()
+	log.Println("kinghttp stopped")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/thinktt/yowking/internal/books"
+	"github.com/thinktt/yowking/internal/moves"
+	"github.com/thinktt/yowking/pkg/models"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+// maxMoveReqBytes is far more than the longest game's move list needs.
+const maxMoveReqBytes = 64 << 10
+
+// server answers the API. slots holds a token per move request being worked
+// on, so no more go to the engine pool than it has processes.
+type server struct {
+	cfg   config
+	slots chan struct{}
+}
+
+func newServer(cfg config) *http.Server {
+	s := &server{cfg: cfg, slots: make(chan struct{}, cfg.concurrency)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /move", s.serveMove)
+	mux.HandleFunc("GET /personalities", s.servePersonalities)
+	mux.HandleFunc("GET /book", s.serveBook)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	return &http.Server{
+		Addr:              cfg.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		// a move may wait for a slot and then think for moveTimeout
+		WriteTimeout:   cfg.queueTimeout + cfg.moveTimeout + 5*time.Second,
+		IdleTimeout:    time.Minute,
+		MaxHeaderBytes: 1 << 16,
+	}
+}
+
+// serveMove takes a models.MoveReq and answers with the models.MoveData, whose
+// error object says why there is no move. The status follows the error code.
+func (s *server) serveMove(w http.ResponseWriter, r *http.Request) {
+	var moveReq models.MoveReq
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMoveReqBytes)).Decode(&moveReq)
+	if err != nil {
+		moveErr := models.NewMoveError(models.ErrBadRequest, fmt.Sprintf("Error unmarshaling data: %v", err))
+		writeMoveData(w, models.ErrMoveData(moveErr))
+		return
+	}
+
+	queueCtx, cancelQueue := context.WithTimeout(r.Context(), s.cfg.queueTimeout)
+	defer cancelQueue()
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-queueCtx.Done():
+		moveErr := models.NewMoveError(models.ErrEngineUnavailable, "every engine is busy, try again later")
+		moveData := models.ErrMoveData(moveErr)
+		moveData.GameId = moveReq.GameId
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.cfg.queueTimeout.Seconds())+1))
+		writeMoveData(w, moveData)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.moveTimeout)
+	defer cancel()
+	moveData, err := moves.HandleMoveReq(ctx, moveReq)
+	if err != nil {
+		code := models.ErrCanceled
+		if errors.Is(err, context.DeadlineExceeded) {
+			code = models.ErrEngineTimeout
+		}
+		moveData = models.ErrMoveData(models.WrapMoveError(code, err))
+		moveData.GameId = moveReq.GameId
+	}
+	writeMoveData(w, moveData)
+}
+
+// servePersonalities lists personalities.CmpMap by name.
+func (s *server) servePersonalities(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, personalities.CmpMap)
+}
+
+// serveBook lists every move the named book has for fen, with its weight.
+func (s *server) serveBook(w http.ResponseWriter, r *http.Request) {
+	fen := r.URL.Query().Get("fen")
+	bookName := r.URL.Query().Get("book")
+	if fen == "" || bookName == "" {
+		writeError(w, http.StatusBadRequest, "fen and book are required")
+		return
+	}
+	// only books straight under books/ can be read
+	if filepath.Base(bookName) != bookName || bookName == "." || bookName == ".." {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bad book name %q", bookName))
+		return
+	}
+	if _, err := books.NewGame(fen); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bad fen: %v", err))
+		return
+	}
+
+	bookMoves, err := books.GetAllBookMoves(fen, bookName)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no book named %q", bookName))
+		return
+	}
+	if err != nil {
+		log.Errorf("Error reading book %s: %v", bookName, err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, bookMoves)
+}
+
+// moveStatus is the HTTP status for a move response: the request's fault,
+// the position's, or ours.
+func moveStatus(moveData models.MoveData) int {
+	if moveData.Error == nil {
+		return http.StatusOK
+	}
+	switch moveData.Error.Code {
+	case models.ErrBadRequest, models.ErrUnknownPersonality, models.ErrIllegalMove, models.ErrEngineRejected:
+		return http.StatusBadRequest
+	case models.ErrGameOver:
+		return http.StatusConflict
+	case models.ErrEngineTimeout:
+		return http.StatusGatewayTimeout
+	case models.ErrEngineUnavailable, models.ErrCanceled:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeMoveData(w http.ResponseWriter, moveData models.MoveData) {
+	if moveData.Error != nil {
+		log.WithField("gameId", moveData.GameId).Error("move request failed: ", moveData.Error)
+	}
+	writeJSON(w, moveStatus(moveData), moveData)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Errorf("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/thinktt/yowking/internal/engine"
+	"github.com/thinktt/yowking/internal/envconf"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+// minAckWait is the AckWait kingworker always used, kept as a floor for
+// personalities calibrated to short clocks.
+const minAckWait = 30 * time.Second
+
+// config is kingworker's tuning, read from the environment.
+type config struct {
+	// maxDeliver is how many times a request is tried before it is dead
+	// lettered (MAX_DELIVER, default 5).
+	maxDeliver uint64
+	// concurrency is how many requests are worked on at once
+	// (WORKER_CONCURRENCY, default 1). The engine pool grows to match.
+	concurrency int
+	// ackWait is long enough for the slowest calibrated personality to
+	// finish its move, so only a dead worker's requests are redelivered.
+	ackWait time.Duration
+	// heartbeat is how often a request being worked on is reported in
+	// progress, which covers manual clock times longer than the calibrated
+	// ones and time spent waiting for an engine.
+	heartbeat time.Duration
+	// shutdownGrace is how long requests in progress get to finish after
+	// SIGTERM before they are handed back (SHUTDOWN_GRACE, default 20s).
+	shutdownGrace time.Duration
+	// httpAddr is where /healthz, /readyz and /metrics are served
+	// (HTTP_ADDR, e.g. :8080), empty for no HTTP listener.
+	httpAddr string
+	// transport is how move requests arrive (WORKER_TRANSPORT): jetstream
+	// pulls from move-req-stream, request answers core NATS requests on
+	// move.get, both does both. Default jetstream.
+	transport string
+}
+
+// jetStream reports whether move-req-stream is consumed.
+func (c config) jetStream() bool {
+	return c.transport == "jetstream" || c.transport == "both"
+}
+
+// requestReply reports whether move.get requests are answered.
+func (c config) requestReply() bool {
+	return c.transport == "request" || c.transport == "both"
+}
+
+func configFromEnv() (config, error) {
+	maxDeliver, err := envconf.Positive("MAX_DELIVER", 5)
+	if err != nil {
+		return config{}, err
+	}
+	concurrency, err := envconf.Positive("WORKER_CONCURRENCY", 1)
+	if err != nil {
+		return config{}, err
+	}
+	shutdownGrace, err := envconf.Duration("SHUTDOWN_GRACE", 20*time.Second)
+	if err != nil {
+		return config{}, err
+	}
+
+	transport := os.Getenv("WORKER_TRANSPORT")
+	switch transport {
+	case "":
+		transport = "jetstream"
+	case "jetstream", "request", "both":
+	default:
+		return config{}, fmt.Errorf("WORKER_TRANSPORT must be jetstream, request or both, got %q", transport)
+	}
+
+	ackWait := max(engine.MoveTimeout(personalities.MaxClockTime()), minAckWait)
+	return config{
+		maxDeliver:    maxDeliver,
+		concurrency:   int(concurrency),
+		ackWait:       ackWait,
+		heartbeat:     ackWait / 3,
+		shutdownGrace: shutdownGrace,
+		httpAddr:      os.Getenv("HTTP_ADDR"),
+		transport:     transport,
+	}, nil
+}
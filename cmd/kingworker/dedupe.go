@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"github.com/thinktt/yowking/internal/moves"
+	"github.com/thinktt/yowking/pkg/models"
+)
+
+// dedupeBucket remembers the move sent for each position, so a redelivered
+// request gets the same answer instead of a second, possibly different, move.
+const dedupeBucket = "move-res-dedupe"
+
+// dedupeTTL is how long an answer is remembered, far longer than any
+// redelivery takes.
+const dedupeTTL = 24 * time.Hour
+
+// dedupe is backed by a KV bucket. A nil *dedupe remembers nothing.
+type dedupe struct {
+	kv nats.KeyValue
+}
+
+// answer is what the bucket holds for a position. Moves guards against a
+// takeback reaching the same ply of the same game by a different line.
+type answer struct {
+	Moves    string          `json:"moves"`
+	MoveData models.MoveData `json:"moveData"`
+}
+
+func newDedupe(js nats.JetStreamContext) (*dedupe, error) {
+	kv, err := js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:  dedupeBucket,
+		History: 1,
+		TTL:     dedupeTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &dedupe{kv: kv}, nil
+}
+
+// moveKey names a position's answer by game, ply and stopId, or is empty
+// when the gameId can't be used in a key.
+func moveKey(moveReq models.MoveReq) string {
+	if !moves.ValidGameId(moveReq.GameId) {
+		return ""
+	}
+	return fmt.Sprintf("%s.%d.%d", moveReq.GameId, len(moveReq.Moves), moveReq.StopId)
+}
+
+// moveMsgId is the Nats-Msg-Id a move for moveReq is published with. It
+// adds a hash of the moves to key, so move-res-stream only drops resends of
+// the same answer.
+func moveMsgId(key string, moveReq models.MoveReq) string {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(moveReq.Moves, " ")))
+	return fmt.Sprintf("%s.%x", key, h.Sum64())
+}
+
+// lookup returns the move already sent for key, if it was sent for the same
+// moves as moveReq.
+func (d *dedupe) lookup(key string, moveReq models.MoveReq, logContext *logrus.Entry) (models.MoveData, bool) {
+	if d == nil || key == "" {
+		return models.MoveData{}, false
+	}
+	entry, err := d.kv.Get(key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return models.MoveData{}, false
+	}
+	if err != nil {
+		logContext.Errorf("Error reading %s: %v", dedupeBucket, err)
+		return models.MoveData{}, false
+	}
+
+	var earlier answer
+	if err := json.Unmarshal(entry.Value(), &earlier); err != nil {
+		logContext.Errorf("Error parsing %s %s: %v", dedupeBucket, key, err)
+		return models.MoveData{}, false
+	}
+	if earlier.Moves != strings.Join(moveReq.Moves, " ") {
+		return models.MoveData{}, false
+	}
+	return earlier.MoveData, true
+}
+
+// store remembers moveData as the answer for key. If another worker got
+// there first its move is returned instead, so only one move is ever sent.
+func (d *dedupe) store(key string, moveReq models.MoveReq, moveData models.MoveData, logContext *logrus.Entry) models.MoveData {
+	if d == nil || key == "" {
+		return moveData
+	}
+	data, err := json.Marshal(answer{Moves: strings.Join(moveReq.Moves, " "), MoveData: moveData})
+	if err != nil {
+		logContext.Errorf("Error encoding move for %s: %v", dedupeBucket, err)
+		return moveData
+	}
+
+	_, err = d.kv.Create(key, data)
+	if errors.Is(err, nats.ErrKeyExists) {
+		if earlier, ok := d.lookup(key, moveReq, logContext); ok {
+			logContext.Println("position was already answered, sending that move:", earlier.CoordinateMove)
+			return earlier
+		}
+		// the key is from a line that was taken back
+		_, err = d.kv.Put(key, data)
+	}
+	if err != nil {
+		logContext.Errorf("Error writing %s: %v", dedupeBucket, err)
+	}
+	return moveData
+}
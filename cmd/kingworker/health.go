@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/thinktt/yowking/internal/engine"
+	"github.com/thinktt/yowking/internal/metrics"
+	"github.com/thinktt/yowking/pkg/models"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+// smokeInterval is how long an engine move, real or smoke test, counts as
+// proof the engine works. It also keeps probes from running the engine
+// every few seconds.
+const smokeInterval = time.Minute
+
+// smokeClockTime is the clock, in centiseconds, a smoke test move gets.
+const smokeClockTime = 10
+
+// smokeTimeout bounds a smoke test, which may have to start a cold engine.
+const smokeTimeout = 30 * time.Second
+
+// health serves /healthz, /readyz and /metrics on HTTP_ADDR.
+type health struct {
+	nc       *nats.Conn
+	metrics  *metrics.Metrics
+	stopping atomic.Bool
+	// ctx ends at shutdown, stopping a smoke test in progress
+	ctx context.Context
+
+	smokeMu      sync.Mutex
+	smokeAt      time.Time
+	smokeErr     error
+	smokeRunning bool
+}
+
+func newHealthServer(addr string, h *health) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", h.serveReady)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		h.metrics.WriteTo(w)
+	})
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+}
+
+// serveReady answers 200 when the worker can take move requests, otherwise
+// 503 with a line per problem.
+func (h *health) serveReady(w http.ResponseWriter, r *http.Request) {
+	var problems []string
+	if h.stopping.Load() {
+		problems = append(problems, "shutting down")
+	}
+	if !h.nc.IsConnected() {
+		problems = append(problems, "not connected to NATS: "+h.nc.Status().String())
+	}
+	if len(personalities.CmpMap) == 0 {
+		problems = append(problems, "no personalities loaded")
+	}
+	if len(problems) == 0 {
+		if err := h.engineCheck(); err != nil {
+			problems = append(problems, "engine smoke test failed: "+err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(problems, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+// engineCheck passes if the engine made a move in the last smokeInterval,
+// otherwise it reports the last smoke test, starting a new one in the
+// background once that is older than smokeInterval. Probes never wait on
+// the engine, so one that gives up can't cancel a search.
+func (h *health) engineCheck() error {
+	if time.Since(h.metrics.LastEngineMove()) < smokeInterval {
+		return nil
+	}
+
+	h.smokeMu.Lock()
+	defer h.smokeMu.Unlock()
+	if !h.smokeRunning && time.Since(h.smokeAt) >= smokeInterval {
+		h.smokeRunning = true
+		go h.runSmoke()
+	}
+	if h.smokeAt.IsZero() {
+		return errors.New("no smoke test has finished yet")
+	}
+	return h.smokeErr
+}
+
+// runSmoke plays a smoke test move and records the result. It runs on the
+// engine the pool keeps for it beyond cfg.concurrency, see main. A test cut
+// short by shutdown says nothing about the engine, so it isn't recorded.
+func (h *health) runSmoke() {
+	defer func() {
+		h.smokeMu.Lock()
+		h.smokeRunning = false
+		h.smokeMu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(h.ctx, smokeTimeout)
+	defer cancel()
+	err := smokeMove(ctx)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	h.smokeMu.Lock()
+	h.smokeErr = err
+	h.smokeAt = time.Now()
+	h.smokeMu.Unlock()
+}
+
+// smokeMove asks the engine for a first move as the first personality.
+func smokeMove(ctx context.Context) error {
+	names := make([]string, 0, len(personalities.CmpMap))
+	for name := range personalities.CmpMap {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return errors.New("no personalities loaded")
+	}
+	sort.Strings(names)
+	cmp := personalities.CmpMap[names[0]]
+
+	moveData, err := engine.GetMove(ctx, models.MoveReq{
+		Moves:     []string{},
+		CmpName:   cmp.Name,
+		GameId:    "readyz",
+		ClockTime: smokeClockTime,
+		CmpVals:   cmp.Vals,
+		Engine:    cmp.Engine,
+		Uci:       cmp.Uci,
+	})
+	if err != nil {
+		return err
+	}
+	if moveData.Err != nil {
+		return errors.New(*moveData.Err)
+	}
+	return nil
+}
@@ -1,19 +1,27 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"errors"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/sirupsen/logrus"
+	"github.com/thinktt/yowking/internal/dlq"
+	"github.com/thinktt/yowking/internal/engine"
+	"github.com/thinktt/yowking/internal/metrics"
 	"github.com/thinktt/yowking/internal/moves"
-	"github.com/thinktt/yowking/pkg/models"
 )
 
 var log = logrus.New()
 
+// drainTimeout bounds the wait for the NATS connection to flush at shutdown.
+const drainTimeout = 5 * time.Second
+
 func main() {
 	token := os.Getenv("NATS_TOKEN")
 	if token == "" {
@@ -28,11 +36,93 @@ func main() {
 		log.Println("NATS_URL set to:", natsUrl)
 	}
 
+	cfg, err := configFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("working on", cfg.concurrency, "move requests at a time, ack wait", cfg.ackWait)
+
+	// readiness smoke tests get an engine of their own, so a probe never
+	// holds up a request
+	poolSize := cfg.concurrency
+	if cfg.httpAddr != "" {
+		poolSize++
+	}
+	moves.Start(poolSize)
+
 	nc, err := nats.Connect(natsUrl, nats.Token(token))
 	if err != nil {
 		log.Fatalf("Error connecting to NATS: %v", err)
 	}
 
+	var js nats.JetStreamContext
+	var dedupe *dedupe
+	var sub *nats.Subscription
+	if cfg.jetStream() {
+		js, dedupe, sub = subscribeStream(nc, cfg)
+	}
+
+	// SIGTERM from docker stop, SIGINT from a terminal
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	moveMetrics := metrics.New()
+	var healthSrv *http.Server
+	if cfg.httpAddr != "" {
+		h := &health{nc: nc, metrics: moveMetrics, ctx: ctx}
+		healthSrv = newHealthServer(cfg.httpAddr, h)
+		go func() {
+			log.Println("serving health and metrics on", cfg.httpAddr)
+			if err := healthSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Error serving HTTP: %v", err)
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			h.stopping.Store(true)
+		}()
+	}
+
+	w := newWorker(nc, js, cfg, dedupe, moveMetrics)
+	var reqSub *nats.Subscription
+	if cfg.requestReply() {
+		reqSub, err = w.serveRequests(nc)
+		if err != nil {
+			log.Fatalf("Error subscribing to %s: %v", requestSubject, err)
+		}
+		log.Println("answering requests on", requestSubject)
+	}
+
+	if sub != nil {
+		w.fetch(ctx, sub)
+	} else {
+		<-ctx.Done()
+	}
+	if reqSub != nil {
+		reqSub.Unsubscribe()
+	}
+	w.finish()
+
+	log.Println("shutting down engines")
+	engine.Shutdown()
+
+	log.Println("draining NATS connection")
+	err = nc.Drain()
+	if err != nil {
+		log.Errorf("Error draining NATS connection: %v", err)
+	}
+	for deadline := time.Now().Add(drainTimeout); nc.IsDraining() && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if healthSrv != nil {
+		healthSrv.Close()
+	}
+	log.Println("kingworker stopped")
+}
+
+// subscribeStream sets up the streams, dead-letter stream and dedupe bucket
+// used with move-req-stream, and subscribes to it as kingworkers.
+func subscribeStream(nc *nats.Conn, cfg config) (nats.JetStreamContext, *dedupe, *nats.Subscription) {
 	// Create a JetStream Context
 	js, err := nc.JetStream()
 	if err != nil {
@@ -61,89 +151,57 @@ func main() {
 		log.Println("move-res-stream found or created")
 	}
 
+	// Create move-req-dlq for requests that can't be answered
+	err = dlq.AddStream(js)
+	if err != nil {
+		log.Printf("Failed to create stream: %v", err)
+	} else {
+		log.Println(dlq.StreamName, "found or created")
+	}
+
+	// Create move-res-dedupe so each position is answered once
+	dedupe, err := newDedupe(js)
+	if err != nil {
+		log.Errorf("Failed to create %s, redelivered requests may get a different move: %v", dedupeBucket, err)
+	} else {
+		log.Println(dedupeBucket, "found or created")
+	}
+
+	err = updateAckWait(js, cfg.ackWait)
+	if err != nil {
+		log.Fatalf("Error updating consumer: %v", err)
+	}
+
 	sub, err := js.PullSubscribe(
 		"move-req",
 		"kingworkers",
 		nats.ManualAck(),
-		nats.AckWait(30*time.Second),
+		nats.AckWait(cfg.ackWait),
 	)
 	if err != nil {
 		log.Fatalf("Error subscribing to stream queue: %v", err)
 	}
 
-	for {
-		msgs, err := sub.Fetch(1)
-		if err != nil && err == nats.ErrTimeout {
-			continue
-		}
-
-		if err != nil {
-			log.Errorf("Error fetching messages: %v", err)
-			continue
-		}
-
-		if len(msgs) == 0 {
-			log.Println("an empty message slice was returned")
-			continue
-		}
-		m := msgs[0]
-
-		meta, err := m.Metadata()
-		if err != nil {
-			log.Errorf("Error retrieving message metadata: %v", err)
-		}
-		log.Println("Received message seq:", meta.Sequence.Stream, "msgId:", meta.Sequence.Consumer)
-
-		// Create a new instance of engine.Settings
-		var moveReq models.MoveReq
-
-		// Unmarshal the JSON data errors will be relayed to via move response
-		err = json.Unmarshal(m.Data, &moveReq)
-		if err != nil {
-			errMsg := fmt.Sprintf("Error unmarshaling data: %v", err)
-			log.Error(errMsg)
-			continue
-		}
-
-		// since we have move-req data we can now log with context
-		logContext := logrus.WithFields(logrus.Fields{
-			"gameId": moveReq.GameId,
-			"moveNo": len(moveReq.Moves),
-		})
-
-		moveRes, err := moves.HandleMoveReq(moveReq)
-		if err != nil {
-			logContext.Errorf("Error handling move request: %v", err)
-			continue
-		}
-
-		err = PubMoveRes(js, moveRes)
-		if err != nil {
-			logContext.Errorf("Error publishing move response: %v", err)
-			// continue
-		}
-		logContext.Println("succesfully published move response")
-
-		m.Ack()
-	}
+	return js, dedupe, sub
 }
 
-// PubMoveRes publishes the move data to the move_res.<gameId> subject
-func PubMoveRes(js nats.JetStreamContext, moveData models.MoveData) error {
-	// Convert your moveData to JSON
-	data, err := json.Marshal(moveData)
-	if err != nil {
-		return err
+// updateAckWait changes the AckWait of an existing kingworkers consumer,
+// which PullSubscribe would otherwise refuse as a config mismatch.
+func updateAckWait(js nats.JetStreamContext, ackWait time.Duration) error {
+	info, err := js.ConsumerInfo("move-req-stream", "kingworkers")
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		return nil
 	}
-
-	// Generate the subject name
-	subject := fmt.Sprintf("move-res.%s", moveData.GameId)
-
-	// Publish the data
-	_, err = js.Publish(subject, data)
 	if err != nil {
 		return err
 	}
+	if info.Config.AckWait == ackWait {
+		return nil
+	}
 
-	return nil
+	log.Printf("changing consumer ack wait from %s to %s", info.Config.AckWait, ackWait)
+	consumerCfg := info.Config
+	consumerCfg.AckWait = ackWait
+	_, err = js.UpdateConsumer("move-req-stream", &consumerCfg)
+	return err
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"github.com/thinktt/yowking/internal/moves"
+	"github.com/thinktt/yowking/pkg/models"
+)
+
+// requestSubject takes a MoveReq as a core NATS request and replies with the
+// MoveData, for callers that would rather not publish to move-req and watch
+// move-res.<gameId>.
+const requestSubject = "move.get"
+
+// requestQueue spreads requests across kingworkers, so each one is answered
+// by a single worker.
+const requestQueue = "kingworkers"
+
+// serveRequests answers requests on requestSubject until the returned
+// subscription is unsubscribed. They share slots with the move-req-stream
+// consumer. A request that arrives with every slot busy, or once shutdown
+// has begun, is answered at once with ENGINE_UNAVAILABLE rather than held
+// in the subscription's callback. Nothing is retried or dead lettered, as a
+// caller that gets an error or no reply can simply ask again.
+func (w *worker) serveRequests(nc *nats.Conn) (*nats.Subscription, error) {
+	return nc.QueueSubscribe(requestSubject, requestQueue, func(m *nats.Msg) {
+		select {
+		case w.slots <- struct{}{}:
+		default:
+			w.refuseRequest(m, "every engine slot is busy")
+			return
+		}
+		if !w.start(func(ctx context.Context) { w.handleRequest(ctx, m) }) {
+			w.refuseRequest(m, "worker is shutting down")
+		}
+	})
+}
+
+// refuseRequest answers a request this worker has no room for with a
+// retryable ENGINE_UNAVAILABLE error.
+func (w *worker) refuseRequest(m *nats.Msg, reason string) {
+	logContext := log.WithField("subject", requestSubject)
+	if m.Reply == "" {
+		logContext.Warn("dropping message without a reply subject: ", reason)
+		return
+	}
+
+	var moveReq models.MoveReq
+	json.Unmarshal(m.Data, &moveReq)
+	logContext = logContext.WithField("gameId", moveReq.GameId)
+	logContext.Warn("refusing move request: ", reason)
+
+	moveErr := models.NewMoveError(models.ErrEngineUnavailable, reason)
+	w.metrics.MoveError(moveErr.Code)
+	moveRes := models.ErrMoveData(moveErr)
+	moveRes.GameId = moveReq.GameId
+	respond(m, moveRes, logContext)
+}
+
+// handleRequest answers one request on its reply inbox.
+func (w *worker) handleRequest(ctx context.Context, m *nats.Msg) {
+	w.metrics.InFlight(1)
+	defer w.metrics.InFlight(-1)
+
+	if m.Reply == "" {
+		log.Warn("dropping message on ", requestSubject, " without a reply subject")
+		return
+	}
+
+	var moveReq models.MoveReq
+	err := json.Unmarshal(m.Data, &moveReq)
+	if err != nil {
+		moveErr := models.NewMoveError(models.ErrBadRequest, fmt.Sprintf("Error unmarshaling data: %v", err))
+		log.Error(moveErr)
+		w.metrics.MoveError(moveErr.Code)
+		moveRes := models.ErrMoveData(moveErr)
+		moveRes.GameId = moveReq.GameId
+		respond(m, moveRes, log.WithField("subject", requestSubject))
+		return
+	}
+
+	logContext := logrus.WithFields(logrus.Fields{
+		"gameId":  moveReq.GameId,
+		"moveNo":  len(moveReq.Moves),
+		"subject": requestSubject,
+	})
+
+	start := time.Now()
+	moveRes, err := moves.HandleMoveReq(w.withThinking(ctx, moveReq, logContext), moveReq)
+	if err != nil {
+		// there is no one to hand the request back to, so say why it stopped
+		logContext.Errorf("Error handling move request: %v", err)
+		moveRes = models.ErrMoveData(models.WrapMoveError(models.ErrCanceled, err))
+		moveRes.GameId = moveReq.GameId
+	}
+	w.observe(moveReq, moveRes, time.Since(start))
+
+	respond(m, moveRes, logContext)
+}
+
+func respond(m *nats.Msg, moveRes models.MoveData, logContext *logrus.Entry) {
+	data, err := json.Marshal(moveRes)
+	if err != nil {
+		logContext.Errorf("Error encoding move response: %v", err)
+		return
+	}
+	if err := m.Respond(data); err != nil {
+		logContext.Errorf("Error sending move reply: %v", err)
+		return
+	}
+	logContext.Println("succesfully replied with move response")
+}
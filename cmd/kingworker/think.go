@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thinktt/yowking/internal/engine"
+	"github.com/thinktt/yowking/internal/moves"
+	"github.com/thinktt/yowking/pkg/models"
+)
+
+// withThinking has the engine's post lines published to
+// move-think.<gameId> for requests that ask for them. They go out on core
+// NATS rather than a stream, since they are only of use while the move is
+// being found.
+func (w *worker) withThinking(ctx context.Context, moveReq models.MoveReq, logContext *logrus.Entry) context.Context {
+	if !moveReq.ShouldPostThinking || !moves.ValidGameId(moveReq.GameId) {
+		return ctx
+	}
+	subject := "move-think." + moveReq.GameId
+	return engine.WithThinking(ctx, func(moveData models.MoveData) {
+		data, err := json.Marshal(models.ThinkData{
+			GameId:      moveReq.GameId,
+			CmpName:     moveReq.CmpName,
+			Ply:         len(moveReq.Moves),
+			Depth:       moveData.Depth,
+			Eval:        moveData.Eval,
+			Time:        moveData.Time,
+			AlgebraMove: moveData.AlgebraMove,
+		})
+		if err != nil {
+			logContext.Errorf("Error encoding thinking: %v", err)
+			return
+		}
+		if err := w.nc.Publish(subject, data); err != nil {
+			logContext.Errorf("Error publishing thinking: %v", err)
+		}
+	})
+}
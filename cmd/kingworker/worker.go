@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+	"github.com/thinktt/yowking/internal/dlq"
+	"github.com/thinktt/yowking/internal/metrics"
+	"github.com/thinktt/yowking/internal/moves"
+	"github.com/thinktt/yowking/pkg/models"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+// nakDelay holds back a request handed back at shutdown, so it goes to
+// another worker rather than straight back to this one.
+const nakDelay = 2 * time.Second
+
+// fetchWait is how long one Fetch waits for requests before polling again.
+const fetchWait = 5 * time.Second
+
+// fallbackResSubject gets the error replies for requests without a usable
+// gameId. The underscore keeps it from clashing with a real game.
+const fallbackResSubject = "move-res._invalid"
+
+// worker answers move requests. Every request ends with a reply on
+// move-res.<gameId>: a move, or a MoveData whose Error says why there is
+// none. Retryable failures are put back for another attempt until
+// maxDeliver deliveries, after which the request goes to the dead-letter
+// stream. maxDeliver is counted here rather than set on the consumer, since
+// the server would drop the message without telling anyone. Core NATS
+// requests are answered on their reply inbox instead, see serveRequests.
+type worker struct {
+	nc *nats.Conn
+	// js is nil when only core NATS requests are served
+	js       nats.JetStreamContext
+	cfg      config
+	dedupe   *dedupe
+	metrics  *metrics.Metrics
+	hostname string
+
+	// slots holds a token per request being worked on, from either
+	// transport, up to cfg.concurrency
+	slots      chan struct{}
+	inProgress sync.WaitGroup
+	// mu guards closed, set once finish starts waiting, after which no new
+	// work is started
+	mu     sync.Mutex
+	closed bool
+	// workCtx is canceled when the shutdown grace period is over
+	workCtx    context.Context
+	cancelWork context.CancelFunc
+}
+
+func newWorker(nc *nats.Conn, js nats.JetStreamContext, cfg config, dedupe *dedupe, metrics *metrics.Metrics) *worker {
+	hostname, _ := os.Hostname()
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	return &worker{
+		nc:         nc,
+		js:         js,
+		cfg:        cfg,
+		dedupe:     dedupe,
+		metrics:    metrics,
+		hostname:   hostname,
+		slots:      make(chan struct{}, cfg.concurrency),
+		workCtx:    workCtx,
+		cancelWork: cancelWork,
+	}
+}
+
+// start works on a request in the background. The caller has already taken
+// a slot, which is given back when work is done. Once shutdown has begun it
+// gives the slot straight back and returns false.
+func (w *worker) start(work func(ctx context.Context)) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		<-w.slots
+		return false
+	}
+
+	w.inProgress.Add(1)
+	go func() {
+		defer w.inProgress.Done()
+		defer func() { <-w.slots }()
+		work(w.workCtx)
+	}()
+	return true
+}
+
+// fetch pulls requests from move-req until ctx ends. It only fetches as
+// many as it has free slots for, so a fetched message never sits waiting
+// while its AckWait runs down.
+func (w *worker) fetch(ctx context.Context, sub *nats.Subscription) {
+	for {
+		// block for one free slot, then take any others that are free
+		select {
+		case w.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		free := 1
+	claim:
+		for free < w.cfg.concurrency {
+			select {
+			case w.slots <- struct{}{}:
+				free++
+			default:
+				break claim
+			}
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchWait)
+		msgs, err := sub.Fetch(free, nats.Context(fetchCtx))
+		cancel()
+		for i := len(msgs); i < free; i++ {
+			<-w.slots
+		}
+		if ctx.Err() != nil && len(msgs) == 0 {
+			return
+		}
+		// nothing arrived within fetchWait, poll again
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			if len(msgs) == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		if err != nil {
+			log.Errorf("Error fetching messages: %v", err)
+			continue
+		}
+
+		if len(msgs) == 0 {
+			log.Println("an empty message slice was returned")
+			continue
+		}
+
+		for _, m := range msgs {
+			if !w.start(func(ctx context.Context) { w.handleMsg(ctx, m) }) {
+				m.NakWithDelay(nakDelay)
+			}
+		}
+	}
+}
+
+// finish waits up to cfg.shutdownGrace for the requests in progress. Any
+// still running after that are canceled, which hands stream requests back
+// with a Nak and answers core NATS requests with a CANCELED error. Nothing
+// new is started once it is called, and it returns once every request is
+// done.
+func (w *worker) finish() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	log.Println("waiting up to", w.cfg.shutdownGrace, "for move requests in progress")
+	done := make(chan struct{})
+	go func() {
+		w.inProgress.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(w.cfg.shutdownGrace):
+		log.Warn("shutdown grace period is over, handing back unfinished move requests")
+		w.cancelWork()
+		<-done
+	}
+	w.cancelWork()
+}
+
+// handleMsg answers one request. ctx is canceled at shutdown, and the
+// request is then handed back for another worker.
+func (w *worker) handleMsg(ctx context.Context, m *nats.Msg) {
+	w.metrics.InFlight(1)
+	defer w.metrics.InFlight(-1)
+
+	var attempts, streamSeq uint64 = 1, 0
+	meta, err := m.Metadata()
+	if err != nil {
+		log.Errorf("Error retrieving message metadata: %v", err)
+	} else {
+		attempts, streamSeq = meta.NumDelivered, meta.Sequence.Stream
+		log.Println("Received message seq:", meta.Sequence.Stream, "msgId:", meta.Sequence.Consumer, "attempt:", attempts)
+	}
+	if attempts > 1 {
+		w.metrics.Redelivery()
+	}
+
+	// Unmarshal the JSON data errors will be relayed to via move response
+	var moveReq models.MoveReq
+	err = json.Unmarshal(m.Data, &moveReq)
+	if err != nil {
+		moveErr := models.NewMoveError(models.ErrBadRequest, fmt.Sprintf("Error unmarshaling data: %v", err))
+		log.Error(moveErr)
+		w.metrics.MoveError(moveErr.Code)
+		// Unmarshal still fills the fields it could decode, so a request
+		// with a bad field but a good gameId is answered where its caller
+		// waits, and only the rest go to fallbackResSubject
+		moveRes := models.ErrMoveData(moveErr)
+		moveRes.GameId = moveReq.GameId
+		w.giveUp(m, moveRes, attempts, streamSeq, log.WithField("seq", streamSeq))
+		return
+	}
+
+	// since we have move-req data we can now log with context
+	logContext := logrus.WithFields(logrus.Fields{
+		"gameId": moveReq.GameId,
+		"moveNo": len(moveReq.Moves),
+		"seq":    streamSeq,
+	})
+
+	// a redelivery of a request that was answered, e.g. when the ack was
+	// lost, gets the same move again
+	key := moveKey(moveReq)
+	if earlier, ok := w.dedupe.lookup(key, moveReq, logContext); ok {
+		logContext.Println("position was already answered, resending move:", earlier.CoordinateMove)
+		w.publishMove(m, earlier, moveMsgId(key, moveReq), logContext)
+		return
+	}
+
+	// a request that keeps taking its worker down never gets a retryable
+	// error back, only redeliveries
+	if attempts > w.cfg.maxDeliver {
+		moveErr := models.NewMoveError(models.ErrEngineCrash, fmt.Sprintf("no result after %d deliveries", attempts-1))
+		logContext.Error(moveErr)
+		w.metrics.MoveError(moveErr.Code)
+		moveRes := models.ErrMoveData(moveErr)
+		moveRes.GameId = moveReq.GameId
+		w.giveUp(m, moveRes, attempts, streamSeq, logContext)
+		return
+	}
+
+	stopHeartbeat := w.heartbeat(m, logContext)
+	start := time.Now()
+	moveRes, err := moves.HandleMoveReq(w.withThinking(ctx, moveReq, logContext), moveReq)
+	stopHeartbeat()
+	if err != nil {
+		logContext.Errorf("Error handling move request, handing it back: %v", err)
+		m.NakWithDelay(nakDelay)
+		return
+	}
+	w.observe(moveReq, moveRes, time.Since(start))
+
+	if moveRes.Error != nil && moveRes.Error.Retryable {
+		if attempts < w.cfg.maxDeliver {
+			logContext.Warnf("attempt %d of %d failed, retrying: %v", attempts, w.cfg.maxDeliver, moveRes.Error)
+			m.Nak()
+			return
+		}
+		w.giveUp(m, moveRes, attempts, streamSeq, logContext)
+		return
+	}
+
+	if moveRes.Error == nil {
+		moveRes = w.dedupe.store(key, moveReq, moveRes, logContext)
+	}
+	w.publishMove(m, moveRes, moveMsgId(key, moveReq), logContext)
+}
+
+// publishMove sends the response and acks the request. A move is published
+// with msgId as its Nats-Msg-Id, so move-res-stream drops a resend of it;
+// errors aren't, since a later attempt may still find a move.
+func (w *worker) publishMove(m *nats.Msg, moveRes models.MoveData, msgId string, logContext *logrus.Entry) {
+	var opts []nats.PubOpt
+	if moveRes.Error == nil {
+		opts = append(opts, nats.MsgId(msgId))
+	}
+
+	err := PubMoveRes(w.js, moveRes, opts...)
+	if err != nil {
+		logContext.Errorf("Error publishing move response: %v", err)
+		m.Nak()
+		return
+	}
+	logContext.Println("succesfully published move response")
+
+	m.Ack()
+}
+
+// observe records a handled request's latency and any error in the
+// metrics.
+func (w *worker) observe(moveReq models.MoveReq, moveRes models.MoveData, d time.Duration) {
+	moveType := moveRes.Type
+	if moveRes.Error != nil {
+		moveType = "error"
+		w.metrics.MoveError(moveRes.Error.Code)
+	}
+	// unknown names would give a series per typo
+	personality := moveReq.CmpName
+	if _, ok := personalities.CmpMap[personality]; !ok {
+		personality = "unknown"
+	}
+	w.metrics.ObserveMove(moveType, personality, d)
+}
+
+// heartbeat reports m in progress every cfg.heartbeat until the returned
+// stop is called, so a long think isn't redelivered to a second worker.
+func (w *worker) heartbeat(m *nats.Msg, logContext *logrus.Entry) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(w.cfg.heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := m.InProgress(); err != nil {
+					logContext.Errorf("Error sending in progress: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// giveUp replies with the error, dead letters the request and terminates
+// it so it is never delivered again.
+func (w *worker) giveUp(m *nats.Msg, moveRes models.MoveData, attempts, streamSeq uint64, logContext *logrus.Entry) {
+	if err := PubMoveRes(w.js, moveRes); err != nil {
+		logContext.Errorf("Error publishing error response: %v", err)
+	}
+
+	err := dlq.Publish(w.js, dlq.Record{
+		Payload:   m.Data,
+		Error:     moveRes.Error,
+		Attempts:  attempts,
+		StreamSeq: streamSeq,
+		FailedAt:  time.Now().UTC(),
+		Worker:    w.hostname,
+	})
+	if err != nil {
+		// leave it for redelivery rather than lose it
+		logContext.Errorf("Error dead lettering move request: %v", err)
+		m.Nak()
+		return
+	}
+	logContext.Warnf("move request dead lettered after %d attempts: %v", attempts, moveRes.Error)
+
+	m.Term()
+}
+
+// PubMoveRes publishes the move data to the move-res.<gameId> subject, or to
+// fallbackResSubject when the gameId can't be used in a subject.
+func PubMoveRes(js nats.JetStreamContext, moveData models.MoveData, opts ...nats.PubOpt) error {
+	// Convert your moveData to JSON
+	data, err := json.Marshal(moveData)
+	if err != nil {
+		return err
+	}
+
+	// Generate the subject name
+	subject := fallbackResSubject
+	if moves.ValidGameId(moveData.GameId) {
+		subject = fmt.Sprintf("move-res.%s", moveData.GameId)
+	}
+
+	// Publish the data
+	_, err = js.Publish(subject, data, opts...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -32,6 +32,7 @@ for (let i = 0; i < cpuSets.length; i+=2) {
       env_file: 
         - env/king.env
       container_name: yowking${cpu1}${cpu2}
+      stop_grace_period: 30s
       networks:
         - yow
       restart: always`
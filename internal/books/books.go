@@ -6,6 +6,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	chess "github.com/corentings/chess/v2"
@@ -20,12 +21,21 @@ type BookMove struct {
 
 var ErrNoBookMove = errors.New("no book move")
 
-// GetMove applies the provided move list and returns a weighted-random move from the named book.
-func GetMove(moves []string, bookName string) (models.MoveData, error) {
-	move, err := HeavyMoveFromMoves(moves, bookName)
+// GetMove applies the provided move list from startFen (the initial position
+// when empty) and returns a weighted-random move from the named book. It
+// returns ErrNoBookMove when the book has nothing for the position, any other
+// error is a *models.MoveError that is also set in the returned MoveData.
+func GetMove(startFen string, moves []string, bookName string) (models.MoveData, error) {
+	fen, err := FENFromMoves(startFen, moves)
 	if err != nil {
-		errStr := err.Error()
-		return models.MoveData{Err: &errStr}, err
+		return errMoveData(models.ErrIllegalMove, err)
+	}
+	move, err := HeavyMoveFromFEN(fen, bookName)
+	if errors.Is(err, ErrNoBookMove) {
+		return models.MoveData{}, err
+	}
+	if err != nil {
+		return errMoveData(models.ErrBookIO, err)
 	}
 
 	return models.MoveData{
@@ -35,23 +45,62 @@ func GetMove(moves []string, bookName string) (models.MoveData, error) {
 	}, nil
 }
 
-func HeavyMoveFromMoves(moves []string, bookName string) (string, error) {
-	fen, err := FENFromMoves(moves)
+func errMoveData(code string, err error) (models.MoveData, error) {
+	moveErr := models.WrapMoveError(code, err)
+	return models.ErrMoveData(moveErr), moveErr
+}
+
+func HeavyMoveFromMoves(startFen string, moves []string, bookName string) (string, error) {
+	fen, err := FENFromMoves(startFen, moves)
 	if err != nil {
 		return "", err
 	}
 	return HeavyMoveFromFEN(fen, bookName)
 }
 
-// FENFromMoves applies a move list from the initial position and returns the resulting FEN.
-func FENFromMoves(moves []string) (string, error) {
-	g := chess.NewGame()
+// FENFromMoves applies a move list from startFen, or the initial position when
+// it is empty, and returns the resulting FEN.
+func FENFromMoves(startFen string, moves []string) (string, error) {
+	g, err := PlayMoves(startFen, moves)
+	if err != nil {
+		return "", err
+	}
+	return g.FEN(), nil
+}
+
+// PlayMoves starts a game at startFen, or the initial position when it is
+// empty, and applies the move list. Moves must be UCI, as in a MoveReq: a
+// looser parse reads g1f3 as the pawn move f3.
+func PlayMoves(startFen string, moves []string) (*chess.Game, error) {
+	g, err := NewGame(startFen)
+	if err != nil {
+		return nil, fmt.Errorf("start fen %q: %w", startFen, err)
+	}
 	for i, s := range moves {
-		if err := pushSloppy(g, s); err != nil {
-			return "", fmt.Errorf("apply move %d (%q): %w", i+1, s, err)
+		if err := g.PushNotationMove(s, chess.UCINotation{}, nil); err != nil {
+			return nil, fmt.Errorf("apply move %d (%q): %w", i+1, s, err)
 		}
 	}
-	return g.FEN(), nil
+	return g, nil
+}
+
+// fenMu serializes FEN parsing: the chess library parses every FEN, the
+// starting position's too, into one package level buffer.
+var fenMu sync.Mutex
+
+// NewGame starts a game at fen, or the initial position when it is empty.
+// Lookups run concurrently, so use it rather than chess.NewGame or chess.FEN.
+func NewGame(fen string) (*chess.Game, error) {
+	fenMu.Lock()
+	defer fenMu.Unlock()
+	if fen == "" {
+		return chess.NewGame(), nil
+	}
+	fenOpt, err := chess.FEN(fen)
+	if err != nil {
+		return nil, err
+	}
+	return chess.NewGame(fenOpt), nil
 }
 
 // HeavyMoveFromFEN selects a weighted-random move, ignoring zero-weight entries.
@@ -78,33 +127,79 @@ func HeavyMoveFromFEN(fen, bookName string) (string, error) {
 	return weighted[r.Intn(len(weighted))], nil
 }
 
-// GetAllBookMoves loads a polyglot book from ./books/<bookName> and returns all moves for the FEN.
+// GetAllBookMoves returns all moves for the FEN in ./books/<bookName>, from
+// the shared Registry so the book is only parsed once.
 func GetAllBookMoves(fen, bookName string) ([]BookMove, error) {
-	return GetAllBookMovesFromDir(fen, "books", bookName)
+	return Default().Moves(fen, bookName)
 }
 
 // GetAllBookMovesFromDir loads a polyglot book from <booksDir>/<bookName> and returns all moves for the FEN.
+// The book is parsed on every call, see Registry for a cached lookup.
 func GetAllBookMovesFromDir(fen, booksDir, bookName string) ([]BookMove, error) {
-	bookPath := filepath.Join(booksDir, bookName)
+	book, err := loadBook(filepath.Join(booksDir, bookName))
+	if err != nil {
+		return nil, err
+	}
+	return findMoves(book, fen)
+}
 
-	f, err := os.Open(bookPath)
+func loadBook(bookPath string) (parsedBook, error) {
+	data, err := os.ReadFile(bookPath)
 	if err != nil {
-		return nil, fmt.Errorf("open polyglot book %q: %w", bookPath, err)
+		return parsedBook{}, fmt.Errorf("open polyglot book %q: %w", bookPath, err)
+	}
+	if err := checkBookSize(int64(len(data))); err != nil {
+		return parsedBook{}, fmt.Errorf("load polyglot book %q: %w", bookPath, err)
 	}
-	defer f.Close()
 
-	book, err := chess.LoadFromReader(f)
+	book, err := chess.LoadFromBytes(data)
 	if err != nil {
-		return nil, fmt.Errorf("load polyglot book %q: %w", bookPath, err)
+		return parsedBook{}, fmt.Errorf("load polyglot book %q: %w", bookPath, err)
 	}
+	return parsedBook{book}, nil
+}
+
+// bookSource is a polyglot book parsed into memory or read from its file.
+type bookSource interface {
+	FindMoves(key uint64) ([]chess.PolyglotEntry, error)
+	Close() error
+}
+
+// parsedBook is a book loaded by chess.LoadFromReader.
+type parsedBook struct {
+	*chess.PolyglotBook
+}
 
+func (b parsedBook) FindMoves(key uint64) ([]chess.PolyglotEntry, error) {
+	return b.PolyglotBook.FindMoves(key), nil
+}
+
+func (b parsedBook) Close() error {
+	return nil
+}
+
+// PositionKey is the polyglot Zobrist key of the FEN's position.
+func PositionKey(fen string) (uint64, error) {
 	hasher := chess.NewChessHasher()
 	hashHex, err := hasher.HashPosition(fen)
 	if err != nil {
-		return nil, fmt.Errorf("hash fen: %w", err)
+		return 0, fmt.Errorf("hash fen: %w", err)
+	}
+	return chess.ZobristHashToUint64(hashHex), nil
+}
+
+// findMoves returns the book's moves for the FEN. It only reads the book, so
+// a book may be shared between goroutines.
+func findMoves(book bookSource, fen string) ([]BookMove, error) {
+	key, err := PositionKey(fen)
+	if err != nil {
+		return nil, err
 	}
 
-	entries := book.FindMoves(chess.ZobristHashToUint64(hashHex))
+	entries, err := book.FindMoves(key)
+	if err != nil {
+		return nil, err
+	}
 	moves := make([]BookMove, 0, len(entries))
 	for _, entry := range entries {
 		moveStr, err := polyglotEntryToUCIMove(entry)
@@ -144,19 +239,3 @@ func polyglotEntryToUCIMove(entry chess.PolyglotEntry) (string, error) {
 
 	return uci, nil
 }
-
-func pushSloppy(g *chess.Game, s string) error {
-	if err := g.PushMove(s, nil); err == nil {
-		return nil
-	}
-	if err := g.PushNotationMove(s, chess.UCINotation{}, nil); err == nil {
-		return nil
-	}
-	if err := g.PushNotationMove(s, chess.LongAlgebraicNotation{}, nil); err == nil {
-		return nil
-	}
-	if err := g.PushNotationMove(s, chess.AlgebraicNotation{}, nil); err == nil {
-		return nil
-	}
-	return fmt.Errorf("invalid move")
-}
@@ -0,0 +1,138 @@
+package books
+
+import (
+	"fmt"
+	"math"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+// MergeSource is a polyglot book to merge and the multiplier for its weights.
+type MergeSource struct {
+	Path       string
+	Multiplier float64
+}
+
+// MergeBooks reads each book and returns one entry per move, weighted by the
+// sum of the move's weight in every book times that book's multiplier,
+// rounded and capped at the largest weight polyglot can hold. Entries come
+// in the order first read, and key 0 header text entries are dropped.
+func MergeBooks(sources []MergeSource) ([]chess.PolyglotEntry, error) {
+	weights := map[keyMove]float64{}
+	var order []keyMove
+	for _, source := range sources {
+		entries, err := ReadPolyglotFile(source.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Key == 0 {
+				continue
+			}
+			km := keyMove{e.Key, e.Move}
+			if _, ok := weights[km]; !ok {
+				order = append(order, km)
+			}
+			weights[km] += float64(e.Weight) * source.Multiplier
+		}
+	}
+
+	merged := make([]chess.PolyglotEntry, 0, len(order))
+	for _, km := range order {
+		weight := math.Min(math.Round(weights[km]), math.MaxUint16)
+		merged = append(merged, chess.PolyglotEntry{
+			Key:    km.key,
+			Move:   km.move,
+			Weight: uint16(math.Max(weight, 0)),
+		})
+	}
+	return merged, nil
+}
+
+// DropLightMoves returns the entries weighing at least minWeight.
+func DropLightMoves(entries []chess.PolyglotEntry, minWeight uint16) []chess.PolyglotEntry {
+	kept := make([]chess.PolyglotEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.Weight >= minWeight {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// LimitPly returns the entries for positions the book reaches from the
+// initial position in fewer than maxPly moves, so no line is longer than
+// maxPly. Positions are found by playing the book's own moves breadth first,
+// and entries for positions it never reaches are dropped too.
+func LimitPly(entries []chess.PolyglotEntry, maxPly int) ([]chess.PolyglotEntry, error) {
+	byKey := map[uint64][]chess.PolyglotEntry{}
+	for _, e := range entries {
+		byKey[e.Key] = append(byKey[e.Key], e)
+	}
+
+	type node struct {
+		pos *chess.Position
+		key uint64
+		ply int
+	}
+	start, err := NewGame("")
+	if err != nil {
+		return nil, err
+	}
+	root := start.Position()
+	rootKey, err := PositionKey(root.String())
+	if err != nil {
+		return nil, err
+	}
+	reached := map[uint64]bool{rootKey: true}
+	queue := []node{{pos: root, key: rootKey}}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if n.ply+1 >= maxPly {
+			// moves here are kept, but the positions after them are too deep
+			continue
+		}
+		for _, e := range byKey[n.key] {
+			move, ok, err := bookMove(n.pos, e)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+			pos := n.pos.Update(&move)
+			key, err := PositionKey(pos.String())
+			if err != nil {
+				return nil, err
+			}
+			if !reached[key] {
+				reached[key] = true
+				queue = append(queue, node{pos: pos, key: key, ply: n.ply + 1})
+			}
+		}
+	}
+
+	kept := make([]chess.PolyglotEntry, 0, len(entries))
+	for _, e := range entries {
+		if reached[e.Key] {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
+
+// bookMove finds the entry's move among the position's legal moves. It is
+// not found when the entry's key only collides with the position's.
+func bookMove(pos *chess.Position, e chess.PolyglotEntry) (chess.Move, bool, error) {
+	uci, err := polyglotEntryToUCIMove(e)
+	if err != nil {
+		return chess.Move{}, false, fmt.Errorf("book entry %#x: %w", e.Key, err)
+	}
+	for _, m := range pos.ValidMoves() {
+		if m.String() == uci {
+			return m, true, nil
+		}
+	}
+	return chess.Move{}, false, nil
+}
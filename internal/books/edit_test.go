@@ -0,0 +1,176 @@
+package books
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+// italian is a line through white castling, with the entry for each ply.
+var italian = []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "f8c5", "e1g1", "g8f6"}
+
+// bookEntry is the entry for playing move after moves from the initial
+// position.
+func bookEntry(t *testing.T, moves []string, move string, weight uint16) chess.PolyglotEntry {
+	t.Helper()
+	g, err := PlayMoves("", moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := g.Position()
+	m, err := chess.UCINotation{}.Decode(pos, move)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := PositionKey(pos.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chess.PolyglotEntry{Key: key, Move: polyglotMove(*m), Weight: weight}
+}
+
+// lineEntries has an entry for every ply of the line.
+func lineEntries(t *testing.T, line []string, weight uint16) []chess.PolyglotEntry {
+	t.Helper()
+	entries := make([]chess.PolyglotEntry, 0, len(line))
+	for ply, move := range line {
+		entries = append(entries, bookEntry(t, line[:ply], move, weight))
+	}
+	return entries
+}
+
+func fenAfter(t *testing.T, moves ...string) string {
+	t.Helper()
+	fen, err := FENFromMoves("", moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fen
+}
+
+func writeBook(t *testing.T, dir, name, text string, entries []chess.PolyglotEntry) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := WritePolyglotFile(path, text, entries); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWritePolyglotFileReadsBack(t *testing.T) {
+	dir := t.TempDir()
+	entries := []chess.PolyglotEntry{
+		bookEntry(t, italian[:6], "e1g1", 7),
+		bookEntry(t, nil, "e2e4", 10),
+		bookEntry(t, []string{"e2e4"}, "e7e5", 3),
+		bookEntry(t, nil, "d2d4", 5),
+	}
+	path := writeBook(t, dir, "test.bin", "made by a test", entries)
+
+	tests := []struct {
+		fen  string
+		want []BookMove
+	}{
+		{fenAfter(t), []BookMove{{Move: "e2e4", Weight: 10}, {Move: "d2d4", Weight: 5}}},
+		{fenAfter(t, "e2e4"), []BookMove{{Move: "e7e5", Weight: 3}}},
+		// stored king takes rook, read back as the king's move
+		{fenAfter(t, italian[:6]...), []BookMove{{Move: "e1g1", Weight: 7}}},
+		{fenAfter(t, "d2d4"), []BookMove{}},
+	}
+	for _, tt := range tests {
+		got, err := GetAllBookMovesFromDir(tt.fen, dir, "test.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.fen, got, tt.want)
+		}
+	}
+
+	// the text takes two key 0 entries ahead of the moves
+	read, err := ReadPolyglotFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2+len(entries) || read[0].Key != 0 || read[1].Key != 0 {
+		t.Fatalf("read %d entries, want 2 key 0 text entries and %d moves", len(read), len(entries))
+	}
+	for i := 3; i < len(read); i++ {
+		if read[i-1].Key > read[i].Key {
+			t.Errorf("entry %d is out of key order", i)
+		}
+	}
+}
+
+func TestMergeBooks(t *testing.T) {
+	dir := t.TempDir()
+	e4, d4, c4 := bookEntry(t, nil, "e2e4", 10), bookEntry(t, nil, "d2d4", 3), bookEntry(t, nil, "c2c4", 1)
+	first := writeBook(t, dir, "first.bin", "first book", []chess.PolyglotEntry{e4, d4})
+	d4.Weight, c4.Weight = 6, 40000
+	second := writeBook(t, dir, "second.bin", "", []chess.PolyglotEntry{d4, c4})
+
+	merged, err := MergeBooks([]MergeSource{{Path: first, Multiplier: 1}, {Path: second, Multiplier: 0.75}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// d4 is 3 + 6*0.75 = 7.5, c4 is 40000*0.75
+	want := []chess.PolyglotEntry{
+		{Key: e4.Key, Move: e4.Move, Weight: 10},
+		{Key: d4.Key, Move: d4.Move, Weight: 8},
+		{Key: c4.Key, Move: c4.Move, Weight: 30000},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("got %v, want %v", merged, want)
+	}
+
+	merged, err = MergeBooks([]MergeSource{{Path: second, Multiplier: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged[1].Weight != math.MaxUint16 {
+		t.Errorf("weight 40000*2 got %d, want it capped at %d", merged[1].Weight, math.MaxUint16)
+	}
+}
+
+func TestDropLightMoves(t *testing.T) {
+	entries := []chess.PolyglotEntry{
+		bookEntry(t, nil, "e2e4", 10),
+		bookEntry(t, nil, "d2d4", 2),
+		bookEntry(t, nil, "c2c4", 3),
+	}
+	got := DropLightMoves(entries, 3)
+	want := []chess.PolyglotEntry{entries[0], entries[2]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLimitPly(t *testing.T) {
+	entries := lineEntries(t, italian, 1)
+	// nothing in the book reaches the position after d2d4
+	orphan := bookEntry(t, []string{"d2d4"}, "d7d5", 1)
+	entries = append(entries, orphan)
+
+	tests := []struct {
+		maxPly int
+		want   []chess.PolyglotEntry
+	}{
+		{10, entries[:8]},
+		// g8f6 is the eighth ply, after castling
+		{8, entries[:8]},
+		{7, entries[:7]},
+		{1, entries[:1]},
+	}
+	for _, tt := range tests {
+		got, err := LimitPly(entries, tt.maxPly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("maxPly %d: got %d entries, want %d", tt.maxPly, len(got), len(tt.want))
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package books
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+// polyglotEntrySize is the size of a polyglot entry on disk: key, move,
+// weight and learn, big endian.
+const polyglotEntrySize = 16
+
+// FileBook finds moves in a polyglot file without parsing it. Polyglot
+// entries are sorted by key, so a lookup is a binary search over the file.
+// Outside Windows the file is memory mapped, on Windows it is read with
+// ReadAt. A FileBook is safe for concurrent use until it is closed.
+type FileBook struct {
+	r       io.ReaderAt
+	entries int64
+	size    int64
+	close   func() error
+}
+
+// OpenFileBook opens the polyglot book at bookPath. The file must not be
+// rewritten in place while it is open; replace it by renaming a new file
+// over it.
+func OpenFileBook(bookPath string) (*FileBook, error) {
+	f, err := os.Open(bookPath)
+	if err != nil {
+		return nil, fmt.Errorf("open polyglot book %q: %w", bookPath, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("stat polyglot book %q: %w", bookPath, err)
+	}
+	if err := checkBookSize(info.Size()); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("load polyglot book %q: %w", bookPath, err)
+	}
+
+	r, closeFn, err := mapFile(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("map polyglot book %q: %w", bookPath, err)
+	}
+	return &FileBook{
+		r:       r,
+		entries: info.Size() / polyglotEntrySize,
+		size:    info.Size(),
+		close:   closeFn,
+	}, nil
+}
+
+// FindMoves returns every entry for the Zobrist key, heaviest first like
+// chess.PolyglotBook.FindMoves.
+func (b *FileBook) FindMoves(key uint64) ([]chess.PolyglotEntry, error) {
+	var buf [polyglotEntrySize]byte
+
+	// find the first entry with a key >= key
+	lo, hi := int64(0), b.entries
+	for lo < hi {
+		mid := int64(uint64(lo+hi) >> 1)
+		entry, err := b.entry(mid, &buf)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Key < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	var entries []chess.PolyglotEntry
+	for i := lo; i < b.entries; i++ {
+		entry, err := b.entry(i, &buf)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Key != key {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Weight > entries[j].Weight })
+	return entries, nil
+}
+
+// Size is the size of the book file in bytes.
+func (b *FileBook) Size() int64 {
+	return b.size
+}
+
+func (b *FileBook) Close() error {
+	return b.close()
+}
+
+func (b *FileBook) entry(i int64, buf *[polyglotEntrySize]byte) (chess.PolyglotEntry, error) {
+	if _, err := b.r.ReadAt(buf[:], i*polyglotEntrySize); err != nil {
+		return chess.PolyglotEntry{}, fmt.Errorf("read polyglot entry %d: %w", i, err)
+	}
+	return decodePolyglotEntry(buf[:]), nil
+}
+
+// checkBookSize rejects a polyglot file that can't hold whole entries, or
+// holds none.
+func checkBookSize(size int64) error {
+	if size == 0 {
+		return errors.New("file is empty")
+	}
+	if size%polyglotEntrySize != 0 {
+		return fmt.Errorf("size %d is not a whole number of entries", size)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package books
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+func TestFileBookMatchesParsedBook(t *testing.T) {
+	// the first probe of the 11 entries lands on key 5, in the middle of its run
+	var entries []chess.PolyglotEntry
+	for i, key := range []uint64{1, 2, 5, 5, 5, 5, 5, 5, 7, 9, 9} {
+		entries = append(entries, chess.PolyglotEntry{Key: key, Move: uint16(0x100 + i), Weight: uint16(i * 3 % 7), Learn: uint32(i)})
+	}
+	path := writeBook(t, t.TempDir(), "test.bin", "", entries)
+
+	fileBook, err := OpenFileBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileBook.Close()
+	parsed, err := loadBook(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		key   uint64
+		moves int
+	}{
+		{"first key", 1, 1},
+		{"around the midpoint", 5, 6},
+		{"last key", 9, 2},
+		{"missing between", 3, 0},
+		{"missing before", 0, 0},
+		{"missing after", 10, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fileBook.FindMoves(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := parsed.FindMoves(tt.key)
+			if len(got) != tt.moves || !reflect.DeepEqual(got, want) {
+				t.Errorf("file book got %v, parsed book %v, want %d moves from both", got, want, tt.moves)
+			}
+		})
+	}
+}
+
+func TestOpenBookRejectsBadSize(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{"empty.bin": 0, "odd.bin": polyglotEntrySize + 1} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if book, err := OpenFileBook(path); err == nil {
+			book.Close()
+			t.Errorf("OpenFileBook(%s) opened a %d byte file", name, size)
+		}
+		if _, err := loadBook(path); err == nil {
+			t.Errorf("loadBook(%s) loaded a %d byte file", name, size)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package books
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the whole file read only. The mapping outlives f, which is
+// closed here.
+func mapFile(f *os.File, size int64) (io.ReaderAt, func() error, error) {
+	defer f.Close()
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(data), func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package books
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the file with ReadAt, one entry per read.
+func mapFile(f *os.File, size int64) (io.ReaderAt, func() error, error) {
+	return f, f.Close, nil
+}
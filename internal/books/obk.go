@@ -0,0 +1,191 @@
+package books
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+// Chessmaster .obk books come in two header variants. Newer books start with
+// obkMagic and a little endian uint32 move count, and their moves start at
+// byte 12. Older books have some other magic, a little endian uint16 count
+// at byte 4, and their moves start at byte 8.
+const (
+	obkMagic        = "BOO!"
+	obkMovesOffset  = 12
+	obkOldMovesOffs = 8
+)
+
+// An OBK move is two bytes, a preorder walk of the book's move tree. The
+// first byte is the from square (rank*8 + file) with two flags, the second
+// the to square with a two bit weight.
+const (
+	obkSquareMask  = 0x3f
+	obkLastSibling = 0x40
+	obkNoChildren  = 0x80
+)
+
+// obkWeights maps the weight bits of an OBK move to a polyglot weight, the
+// same way obk2bin does.
+var obkWeights = [4]uint16{0, 2, 5, 9}
+
+// obkHeaderText is written at the front of converted books as key 0 entries,
+// as obk2bin does, so a converted book matches the one obk2bin built.
+const obkHeaderText = "@PG@\n1.0\n2\n1\nnormal\nCreated by OBK2BIN, (C) 2017 Graham O'Neill"
+
+// ReadOBK reads a Chessmaster book and returns its moves as polyglot entries,
+// in the order the book lists them. A position reached by more than one line
+// has an entry for each. Castling is encoded king takes rook, as polyglot
+// expects.
+func ReadOBK(r io.Reader) ([]chess.PolyglotEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read obk book: %w", err)
+	}
+
+	var count int
+	offset := obkMovesOffset
+	if len(data) >= 4 && string(data[:4]) == obkMagic {
+		if len(data) < obkMovesOffset {
+			return nil, errors.New("obk book is too short for its header")
+		}
+		count = int(binary.LittleEndian.Uint32(data[4:8]))
+	} else {
+		if len(data) < obkOldMovesOffs {
+			return nil, errors.New("obk book is too short for its header")
+		}
+		count = int(binary.LittleEndian.Uint16(data[4:6]))
+		offset = obkOldMovesOffs
+	}
+	if count == 0 {
+		return nil, errors.New("obk book has no moves")
+	}
+
+	type node struct {
+		pos         *chess.Position
+		key         uint64
+		lastSibling bool
+	}
+	start, err := NewGame("")
+	if err != nil {
+		return nil, err
+	}
+	root := start.Position()
+	rootKey, err := PositionKey(root.String())
+	if err != nil {
+		return nil, err
+	}
+	// stack holds the line being read, the root first
+	stack := []node{{pos: root, key: rootKey}}
+
+	entries := make([]chess.PolyglotEntry, 0, count)
+	for ; ; offset += 2 {
+		if offset+2 > len(data) {
+			return nil, fmt.Errorf("obk book ends in the middle of a line after %d moves", len(entries))
+		}
+		fromByte, toByte := data[offset], data[offset+1]
+		parent := stack[len(stack)-1]
+
+		move, err := obkMove(parent.pos, chess.Square(fromByte&obkSquareMask), chess.Square(toByte&obkSquareMask))
+		if err != nil {
+			return nil, fmt.Errorf("obk move %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, chess.PolyglotEntry{
+			Key:    parent.key,
+			Move:   polyglotMove(move),
+			Weight: obkWeights[toByte>>6],
+		})
+
+		child := node{pos: parent.pos.Update(&move), lastSibling: fromByte&obkLastSibling != 0}
+		if fromByte&obkNoChildren == 0 {
+			child.key, err = PositionKey(child.pos.String())
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, child)
+			continue
+		}
+
+		// the line ends here, so the next move is a sibling of the deepest
+		// move on it that has one, or there is none and the book is done
+		if !child.lastSibling {
+			continue
+		}
+		for len(stack) > 1 && stack[len(stack)-1].lastSibling {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 1 {
+			if len(entries) != count {
+				return nil, fmt.Errorf("obk book header says %d moves, its move tree has %d", count, len(entries))
+			}
+			return entries, nil
+		}
+		stack = stack[:len(stack)-1]
+	}
+}
+
+// obkMove finds the legal move between the squares. A pawn reaching the last
+// rank promotes to a queen, the only promotion an OBK move can describe.
+func obkMove(pos *chess.Position, from, to chess.Square) (chess.Move, error) {
+	for _, m := range pos.ValidMoves() {
+		if m.S1() == from && m.S2() == to && (m.Promo() == chess.NoPieceType || m.Promo() == chess.Queen) {
+			return m, nil
+		}
+	}
+	return chess.Move{}, fmt.Errorf("%s%s is not legal in %s", from, to, pos)
+}
+
+// polyglotMove encodes a move as polyglot does, castling as the king taking
+// its own rook. It is the inverse of polyglotEntryToUCIMove.
+func polyglotMove(m chess.Move) uint16 {
+	to := m.S2()
+	switch {
+	case m.HasTag(chess.KingSideCastle):
+		to = chess.NewSquare(chess.FileH, to.Rank())
+	case m.HasTag(chess.QueenSideCastle):
+		to = chess.NewSquare(chess.FileA, to.Rank())
+	}
+	return chess.PolyglotMove{
+		FromFile:  int(m.S1().File()),
+		FromRank:  int(m.S1().Rank()),
+		ToFile:    int(to.File()),
+		ToRank:    int(to.Rank()),
+		Promotion: m.Promo().ToPolyglotPromotionValue(),
+	}.Encode()
+}
+
+// ConvertOBK writes the Chessmaster book at obkPath as a polyglot book at
+// binPath, and returns the number of moves written. Entries are sorted by
+// key, keeping the book's order within a position, and a move a position
+// reaches by more than one line is written once, with the weight of its
+// first line.
+func ConvertOBK(obkPath, binPath string) (int, error) {
+	f, err := os.Open(obkPath)
+	if err != nil {
+		return 0, fmt.Errorf("open obk book %q: %w", obkPath, err)
+	}
+	defer f.Close()
+	entries, err := ReadOBK(bufio.NewReader(f))
+	if err != nil {
+		return 0, fmt.Errorf("read obk book %q: %w", obkPath, err)
+	}
+
+	seen := make(map[keyMove]bool, len(entries))
+	unique := entries[:0]
+	for _, e := range entries {
+		if seen[keyMove{e.Key, e.Move}] {
+			continue
+		}
+		seen[keyMove{e.Key, e.Move}] = true
+		unique = append(unique, e)
+	}
+	if err := WritePolyglotFile(binPath, obkHeaderText, unique); err != nil {
+		return 0, err
+	}
+	return len(unique), nil
+}
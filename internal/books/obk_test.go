@@ -0,0 +1,133 @@
+package books
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// obk2binBooksHash is build/build-all.sh's hash of the books obk2bin.exe
+// makes from the Chessmaster books: md5sum of every .bin, in name order,
+// hashed again.
+const obk2binBooksHash = "55fe7c8c118574aa7fde1f713dce046d"
+
+// testdata/old.obk is a small book in the old header variant, and new.obk
+// the same book after the patch build/normalizeBooks.js applies. Their
+// tree is e2e4 (e7e5, d7d5 e4e5 f7f5 e1e2 e8f7) and a2a4, with each of the
+// four weights, a2a4's being zero. obk.bin is the book they should convert
+// to. It was put together by hand from the Zobrist keys the polyglot spec
+// gives for that line, not by ConvertOBK, and not by obk2bin.exe either,
+// see TestConvertOBKMatchesObk2bin.
+func TestConvertOBK(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("testdata", "obk.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"old.obk", "new.obk"} {
+		t.Run(name, func(t *testing.T) {
+			binPath := filepath.Join(t.TempDir(), "book.bin")
+			n, err := ConvertOBK(filepath.Join("testdata", name), binPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// obk2bin keeps zero weight moves unless run with -z
+			if n != 8 {
+				t.Errorf("wrote %d moves, want all 8", n)
+			}
+
+			got, err := os.ReadFile(binPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("converted book differs from testdata/obk.bin:\ngot  %x\nwant %x", got, want)
+			}
+		})
+	}
+}
+
+func TestReadOBKRejectsBadBooks(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "new.obk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	withCount := func(count uint32) []byte {
+		book := bytes.Clone(data)
+		binary.LittleEndian.PutUint32(book[4:8], count)
+		return book
+	}
+
+	tests := map[string][]byte{
+		"truncated":      data[:len(data)-2],
+		"count too high": withCount(9),
+		"count too low":  withCount(7),
+	}
+	for name, book := range tests {
+		if _, err := ReadOBK(bytes.NewReader(book)); err == nil {
+			t.Errorf("%s: read without an error", name)
+		}
+	}
+}
+
+// TestConvertOBKMatchesObk2bin converts the Chessmaster books in
+// $YOWKING_CM_BOOKS, assets/cm/books in a full checkout, and checks them
+// against obk2bin.exe: byte for byte against any .bin obk2bin left next to
+// its .obk, and as a set against obk2binBooksHash.
+func TestConvertOBKMatchesObk2bin(t *testing.T) {
+	dir := os.Getenv("YOWKING_CM_BOOKS")
+	if dir == "" {
+		t.Skip("set YOWKING_CM_BOOKS to the Chessmaster books to compare with obk2bin")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	var names []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !strings.EqualFold(ext, ".obk") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ext) + ".bin"
+		binPath := filepath.Join(out, name)
+		if _, err := ConvertOBK(filepath.Join(dir, entry.Name()), binPath); err != nil {
+			t.Errorf("%s: %v", entry.Name(), err)
+			continue
+		}
+		names = append(names, name)
+
+		want, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		got, err := os.ReadFile(binPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from obk2bin's %s", entry.Name(), name)
+		}
+	}
+
+	sort.Strings(names)
+	var sums strings.Builder
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&sums, "%x  %s\n", md5.Sum(data), name)
+	}
+	if got := fmt.Sprintf("%x", md5.Sum([]byte(sums.String()))); got != obk2binBooksHash {
+		t.Errorf("books hash got %s, want %s from build/build-all.sh", got, obk2binBooksHash)
+	}
+}
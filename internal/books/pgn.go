@@ -0,0 +1,122 @@
+package books
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+// PGNOptions choose which moves of a PGN collection go into a book and what
+// each is worth.
+type PGNOptions struct {
+	// Player keeps only games whose White or Black tag contains it, ignoring
+	// case, and only the moves that player made. Empty keeps every game.
+	Player string
+	// Side keeps only moves by that colour, and with Player only games the
+	// player had that colour in. chess.NoColor keeps both.
+	Side chess.Color
+	// MaxPly is how many plies of each game are read.
+	MaxPly int
+	// Win, Draw and Loss are added to a move's weight each time it is played
+	// in a game its side won, drew or lost. A game without a result counts
+	// as drawn.
+	Win, Draw, Loss uint
+}
+
+// PGNStats count what BookFromPGN read.
+type PGNStats struct {
+	Games   int
+	Used    int
+	Skipped int
+}
+
+// BookFromPGN reads every game in r and returns an entry for each move
+// played in the first MaxPly plies of the games that pass the options,
+// weighted by the results of the games it was played in. Weights are capped
+// at the largest weight polyglot can hold, and entries come in the order
+// first played. Games the PGN parser rejects are skipped and counted.
+func BookFromPGN(r io.Reader, opts PGNOptions) ([]chess.PolyglotEntry, PGNStats, error) {
+	var stats PGNStats
+	weights := map[keyMove]uint64{}
+	var order []keyMove
+
+	scanner := chess.NewScanner(r)
+	for scanner.HasNext() {
+		stats.Games++
+		game, err := scanner.ParseNext()
+		if err != nil {
+			stats.Skipped++
+			continue
+		}
+
+		sides := pgnSides(game, opts)
+		if len(sides) == 0 {
+			continue
+		}
+		stats.Used++
+
+		positions := game.Positions()
+		for ply, move := range game.Moves() {
+			if ply >= opts.MaxPly || ply >= len(positions) {
+				break
+			}
+			pos := positions[ply]
+			if !sides[pos.Turn()] {
+				continue
+			}
+			key, err := PositionKey(pos.String())
+			if err != nil {
+				return nil, stats, fmt.Errorf("game %d: %w", stats.Games, err)
+			}
+
+			km := keyMove{key, polyglotMove(*move)}
+			if _, ok := weights[km]; !ok {
+				order = append(order, km)
+			}
+			weights[km] += uint64(pgnScore(game.Outcome(), pos.Turn(), opts))
+		}
+	}
+
+	entries := make([]chess.PolyglotEntry, 0, len(order))
+	for _, km := range order {
+		entries = append(entries, chess.PolyglotEntry{
+			Key:    km.key,
+			Move:   km.move,
+			Weight: uint16(min(weights[km], math.MaxUint16)),
+		})
+	}
+	return entries, stats, nil
+}
+
+// pgnSides are the colours whose moves are read from the game, none when
+// the game doesn't pass the options.
+func pgnSides(game *chess.Game, opts PGNOptions) map[chess.Color]bool {
+	sides := map[chess.Color]bool{}
+	for _, color := range []chess.Color{chess.White, chess.Black} {
+		if opts.Side != chess.NoColor && opts.Side != color {
+			continue
+		}
+		if opts.Player != "" {
+			name := game.GetTagPair(color.Name())
+			if !strings.Contains(strings.ToLower(name), strings.ToLower(opts.Player)) {
+				continue
+			}
+		}
+		sides[color] = true
+	}
+	return sides
+}
+
+func pgnScore(outcome chess.Outcome, mover chess.Color, opts PGNOptions) uint {
+	switch {
+	case outcome == chess.WhiteWon && mover == chess.White, outcome == chess.BlackWon && mover == chess.Black:
+		return opts.Win
+	case outcome == chess.WhiteWon, outcome == chess.BlackWon:
+		return opts.Loss
+	default:
+		return opts.Draw
+	}
+}
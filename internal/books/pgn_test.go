@@ -0,0 +1,93 @@
+package books
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+// castleE1H1 is e1h1 in polyglot's move encoding: from file 4 rank 0 in bits
+// 6-11, to file 7 rank 0 in bits 0-5.
+const castleE1H1 = 4<<6 | 7
+
+func TestBookFromPGN(t *testing.T) {
+	castle := bookEntry(t, italian[:6], "e1g1", 2)
+	castle.Move = castleE1H1
+	queensGambit := []string{"d2d4", "d7d5", "c2c4"}
+
+	tests := []struct {
+		name  string
+		opts  PGNOptions
+		stats PGNStats
+		want  []chess.PolyglotEntry
+	}{
+		{
+			name:  "player",
+			opts:  PGNOptions{Player: "karpov", MaxPly: 7, Win: 2, Draw: 1},
+			stats: PGNStats{Games: 4, Used: 3},
+			want: []chess.PolyglotEntry{
+				// won with white, then drawn with white
+				bookEntry(t, nil, "e2e4", 3),
+				bookEntry(t, italian[:2], "g1f3", 2),
+				bookEntry(t, italian[:4], "f1c4", 2),
+				castle,
+				bookEntry(t, queensGambit[:1], "d7d5", 2),
+				bookEntry(t, queensGambit, "e7e6", 2),
+			},
+		},
+		{
+			name:  "ply limit",
+			opts:  PGNOptions{Player: "karpov", MaxPly: 6, Win: 2, Draw: 1},
+			stats: PGNStats{Games: 4, Used: 3},
+			want: []chess.PolyglotEntry{
+				bookEntry(t, nil, "e2e4", 3),
+				bookEntry(t, italian[:2], "g1f3", 2),
+				bookEntry(t, italian[:4], "f1c4", 2),
+				bookEntry(t, queensGambit[:1], "d7d5", 2),
+				bookEntry(t, queensGambit, "e7e6", 2),
+			},
+		},
+		{
+			name:  "player as black",
+			opts:  PGNOptions{Player: "karpov", Side: chess.Black, MaxPly: 7, Win: 2, Draw: 1},
+			stats: PGNStats{Games: 4, Used: 1},
+			want: []chess.PolyglotEntry{
+				bookEntry(t, queensGambit[:1], "d7d5", 2),
+				bookEntry(t, queensGambit, "e7e6", 2),
+			},
+		},
+		{
+			name:  "black's moves",
+			opts:  PGNOptions{Side: chess.Black, MaxPly: 2, Win: 5, Draw: 2, Loss: 1},
+			stats: PGNStats{Games: 4, Used: 4},
+			want: []chess.PolyglotEntry{
+				// lost twice
+				bookEntry(t, italian[:1], "e7e5", 2),
+				bookEntry(t, queensGambit[:1], "d7d5", 5),
+				bookEntry(t, italian[:1], "c7c5", 2),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open("testdata/games.pgn")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			entries, stats, err := BookFromPGN(f, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats != tt.stats {
+				t.Errorf("stats got %+v, want %+v", stats, tt.stats)
+			}
+			if !reflect.DeepEqual(entries, tt.want) {
+				t.Errorf("got %v, want %v", entries, tt.want)
+			}
+		})
+	}
+}
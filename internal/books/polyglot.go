@@ -0,0 +1,107 @@
+package books
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+// keyMove identifies a book move: the same move in the same position.
+type keyMove struct {
+	key  uint64
+	move uint16
+}
+
+// ReadPolyglotFile returns every entry of the polyglot book at bookPath in
+// file order, including any key 0 header text entries.
+func ReadPolyglotFile(bookPath string) ([]chess.PolyglotEntry, error) {
+	data, err := os.ReadFile(bookPath)
+	if err != nil {
+		return nil, fmt.Errorf("open polyglot book %q: %w", bookPath, err)
+	}
+	if len(data)%polyglotEntrySize != 0 {
+		return nil, fmt.Errorf("load polyglot book %q: size %d is not a whole number of entries", bookPath, len(data))
+	}
+
+	entries := make([]chess.PolyglotEntry, 0, len(data)/polyglotEntrySize)
+	for off := 0; off < len(data); off += polyglotEntrySize {
+		entries = append(entries, decodePolyglotEntry(data[off:off+polyglotEntrySize]))
+	}
+	return entries, nil
+}
+
+// WritePolyglotFile writes entries as a polyglot book at bookPath, sorted by
+// key and otherwise in the order given, after text as key 0 entries when it
+// is not empty. The file is written next to bookPath and renamed over it, so
+// a worker reading the old book never sees half of the new one.
+func WritePolyglotFile(bookPath, text string, entries []chess.PolyglotEntry) error {
+	sorted := append([]chess.PolyglotEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	tmp, err := os.CreateTemp(filepath.Dir(bookPath), filepath.Base(bookPath)+".*")
+	if err != nil {
+		return fmt.Errorf("create polyglot book %q: %w", bookPath, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = writePolyglotText(w, text)
+	for _, e := range sorted {
+		if err != nil {
+			break
+		}
+		err = writePolyglotEntry(w, e)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), bookPath)
+	}
+	if err != nil {
+		return fmt.Errorf("write polyglot book %q: %w", bookPath, err)
+	}
+	return nil
+}
+
+func decodePolyglotEntry(buf []byte) chess.PolyglotEntry {
+	return chess.PolyglotEntry{
+		Key:    binary.BigEndian.Uint64(buf[0:8]),
+		Move:   binary.BigEndian.Uint16(buf[8:10]),
+		Weight: binary.BigEndian.Uint16(buf[10:12]),
+		Learn:  binary.BigEndian.Uint32(buf[12:16]),
+	}
+}
+
+func writePolyglotEntry(w io.Writer, e chess.PolyglotEntry) error {
+	var buf [polyglotEntrySize]byte
+	binary.BigEndian.PutUint64(buf[0:8], e.Key)
+	binary.BigEndian.PutUint16(buf[8:10], e.Move)
+	binary.BigEndian.PutUint16(buf[10:12], e.Weight)
+	binary.BigEndian.PutUint32(buf[12:16], e.Learn)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// writePolyglotText writes text as key 0 entries, eight bytes in each,
+// padding the last with zeros. Lookups never hash to key 0.
+func writePolyglotText(w io.Writer, text string) error {
+	for len(text) > 0 {
+		var buf [polyglotEntrySize]byte
+		n := copy(buf[8:], text)
+		text = text[n:]
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
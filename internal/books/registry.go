@@ -0,0 +1,273 @@
+package books
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bookCheckInterval is how often a cached book's file is checked for
+// changes, so a lookup is usually just a map hit and a binary search.
+const bookCheckInterval = 2 * time.Second
+
+// Ways a Registry can hold its books.
+const (
+	// BookModeMemory parses each book into the heap with chess.LoadFromReader.
+	BookModeMemory = "memory"
+	// BookModeMapped searches each book file in place, see FileBook.
+	BookModeMapped = "mmap"
+)
+
+// Registry keeps polyglot books from a directory open, shared by every
+// goroutine. Books are opened on first use, and once parsed books take
+// more than maxBytes the least recently used are dropped. A book whose file
+// changes size or modification time is opened again.
+type Registry struct {
+	dir      string
+	mode     string
+	maxBytes int64
+
+	mu    sync.Mutex
+	books map[string]*list.Element
+	// lru has the most recently used book at the front
+	lru    *list.List
+	bytes  int64
+	mapped int64
+}
+
+type cachedBook struct {
+	name    string
+	book    bookSource
+	size    int64
+	modTime time.Time
+	checked time.Time
+	// refs counts lookups in progress; a dropped book is only closed once
+	// none are left, since closing unmaps a mapped book
+	refs    int
+	dropped bool
+}
+
+// RegistryStats describe what a Registry holds. Bytes counts the files of
+// parsed books, which is what their entries take in the heap, and Mapped
+// the files of mapped books.
+type RegistryStats struct {
+	Mode     string
+	Books    int
+	Bytes    int64
+	Mapped   int64
+	MaxBytes int64
+}
+
+// NewRegistry opens books from dir in the given mode, using no more than
+// maxBytes of heap for parsed books, or any amount when maxBytes is 0.
+func NewRegistry(dir, mode string, maxBytes int64) *Registry {
+	return &Registry{
+		dir:      dir,
+		mode:     mode,
+		maxBytes: maxBytes,
+		books:    map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+var (
+	defaultRegistry     *Registry
+	defaultRegistryOnce sync.Once
+)
+
+// Default returns the shared registry for ./books. BOOK_MODE picks memory
+// (the default) or mmap, and BOOK_CACHE_MB caps the heap parsed books use
+// when it is set. With BOOK_PRELOAD=true every book is opened the first
+// time it is called, so workers should call it at startup.
+func Default() *Registry {
+	defaultRegistryOnce.Do(func() {
+		mode := os.Getenv("BOOK_MODE")
+		switch mode {
+		case BookModeMemory, BookModeMapped:
+		case "":
+			mode = BookModeMemory
+		default:
+			fmt.Fprintf(os.Stderr, "BOOK_MODE must be %s or %s, got %q, using %s\n", BookModeMemory, BookModeMapped, mode, BookModeMemory)
+			mode = BookModeMemory
+		}
+
+		var maxBytes int64
+		if value := os.Getenv("BOOK_CACHE_MB"); value != "" {
+			mb, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || mb < 0 {
+				fmt.Fprintf(os.Stderr, "BOOK_CACHE_MB must be a number of megabytes, got %q, not capping the book cache\n", value)
+			} else {
+				maxBytes = mb << 20
+			}
+		}
+		defaultRegistry = NewRegistry("books", mode, maxBytes)
+
+		if strings.EqualFold(os.Getenv("BOOK_PRELOAD"), "true") {
+			if err := defaultRegistry.LoadAll(); err != nil {
+				fmt.Fprintln(os.Stderr, "Error preloading books:", err)
+			}
+			stats := defaultRegistry.Stats()
+			fmt.Fprintf(os.Stderr, "books preloaded (%s): %d, %.2f MB\n", stats.Mode, stats.Books, float64(stats.Bytes+stats.Mapped)/(1<<20))
+		}
+	})
+	return defaultRegistry
+}
+
+// Moves returns all moves for the FEN in the named book. Errors wrap the os
+// error, so a missing book is fs.ErrNotExist.
+func (r *Registry) Moves(fen, bookName string) ([]BookMove, error) {
+	cached, err := r.acquire(bookName)
+	if err != nil {
+		return nil, err
+	}
+	defer r.release(cached)
+	return findMoves(cached.book, fen)
+}
+
+// LoadAll opens every .bin book in the directory, stopping early once
+// parsed books reach the memory cap.
+func (r *Registry) LoadAll() error {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return fmt.Errorf("read books dir: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(strings.ToLower(e.Name()), ".bin") {
+			continue
+		}
+		cached, err := r.acquire(e.Name())
+		if err != nil {
+			return err
+		}
+		r.release(cached)
+		if r.maxBytes > 0 && r.Stats().Bytes >= r.maxBytes {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Stats reports the books the registry holds and the memory they take.
+func (r *Registry) Stats() RegistryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RegistryStats{Mode: r.mode, Books: r.lru.Len(), Bytes: r.bytes, Mapped: r.mapped, MaxBytes: r.maxBytes}
+}
+
+// acquire returns the open book, opening it when it isn't cached or its
+// file has changed. It must be given back with release.
+func (r *Registry) acquire(bookName string) (*cachedBook, error) {
+	r.mu.Lock()
+	if cached := r.get(bookName); cached != nil && time.Since(cached.checked) < bookCheckInterval {
+		cached.refs++
+		r.mu.Unlock()
+		return cached, nil
+	}
+	r.mu.Unlock()
+
+	bookPath := filepath.Join(r.dir, bookName)
+	info, err := os.Stat(bookPath)
+	if err != nil {
+		r.remove(bookName)
+		return nil, fmt.Errorf("open polyglot book %q: %w", bookPath, err)
+	}
+
+	r.mu.Lock()
+	if cached := r.get(bookName); cached != nil && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		cached.checked = time.Now()
+		cached.refs++
+		r.mu.Unlock()
+		return cached, nil
+	}
+	r.mu.Unlock()
+
+	// opened without the lock so lookups in other books carry on; when two
+	// goroutines open the same book the last one is kept
+	var book bookSource
+	if r.mode == BookModeMapped {
+		book, err = OpenFileBook(bookPath)
+	} else {
+		book, err = loadBook(bookPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached := &cachedBook{
+		name:    bookName,
+		book:    book,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+		checked: time.Now(),
+		refs:    1,
+	}
+	r.add(cached)
+	return cached, nil
+}
+
+func (r *Registry) release(cached *cachedBook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cached.refs--
+	if cached.dropped && cached.refs == 0 {
+		cached.book.Close()
+	}
+}
+
+// get returns the cached book and marks it used. r.mu must be held.
+func (r *Registry) get(bookName string) *cachedBook {
+	el, ok := r.books[bookName]
+	if !ok {
+		return nil
+	}
+	r.lru.MoveToFront(el)
+	return el.Value.(*cachedBook)
+}
+
+func (r *Registry) add(cached *cachedBook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeLocked(cached.name)
+	r.books[cached.name] = r.lru.PushFront(cached)
+	if r.mode == BookModeMapped {
+		r.mapped += cached.size
+	} else {
+		r.bytes += cached.size
+	}
+
+	// the book just added always stays, even if it is over the cap alone
+	for r.maxBytes > 0 && r.bytes > r.maxBytes && r.lru.Len() > 1 {
+		r.removeLocked(r.lru.Back().Value.(*cachedBook).name)
+	}
+}
+
+func (r *Registry) remove(bookName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeLocked(bookName)
+}
+
+func (r *Registry) removeLocked(bookName string) {
+	el, ok := r.books[bookName]
+	if !ok {
+		return
+	}
+	cached := el.Value.(*cachedBook)
+	if r.mode == BookModeMapped {
+		r.mapped -= cached.size
+	} else {
+		r.bytes -= cached.size
+	}
+	r.lru.Remove(el)
+	delete(r.books, bookName)
+
+	cached.dropped = true
+	if cached.refs == 0 {
+		cached.book.Close()
+	}
+}
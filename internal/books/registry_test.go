@@ -0,0 +1,239 @@
+package books
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	chess "github.com/corentings/chess/v2"
+)
+
+// closeWatch records when the book it wraps is closed.
+type closeWatch struct {
+	bookSource
+	closed atomic.Bool
+}
+
+func (b *closeWatch) Close() error {
+	b.closed.Store(true)
+	return b.bookSource.Close()
+}
+
+// watchClose swaps the cached book for one that records its Close.
+func watchClose(r *Registry, cached *cachedBook) *closeWatch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	watch := &closeWatch{bookSource: cached.book}
+	cached.book = watch
+	return watch
+}
+
+// expireChecks makes the next lookup in each cached book look at its file.
+func expireChecks(r *Registry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, el := range r.books {
+		el.Value.(*cachedBook).checked = time.Time{}
+	}
+}
+
+func cachedNames(r *Registry) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for el := r.lru.Front(); el != nil; el = el.Next() {
+		names = append(names, el.Value.(*cachedBook).name)
+	}
+	return names
+}
+
+// writeOpeningBooks writes books A.bin, B.bin and C.bin, each of the same
+// size, with e2e4 weighted 1, 2 and 3.
+func writeOpeningBooks(t *testing.T, dir string) int64 {
+	t.Helper()
+	for i, name := range []string{"A.bin", "B.bin", "C.bin"} {
+		writeBook(t, dir, name, "", []chess.PolyglotEntry{
+			bookEntry(t, nil, "e2e4", uint16(i+1)),
+			bookEntry(t, nil, "d2d4", 1),
+		})
+	}
+	return 2 * polyglotEntrySize
+}
+
+func TestRegistryDropsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	size := writeOpeningBooks(t, dir)
+	r := NewRegistry(dir, BookModeMemory, 2*size)
+	start := fenAfter(t)
+
+	for _, name := range []string{"A.bin", "B.bin", "A.bin", "C.bin"} {
+		if _, err := r.Moves(start, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// B was used least recently when C came in
+	if got, want := cachedNames(r), []string{"C.bin", "A.bin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cached got %v, want %v", got, want)
+	}
+	if stats := r.Stats(); stats.Books != 2 || stats.Bytes != 2*size {
+		t.Errorf("stats got %+v, want 2 books of %d bytes", stats, 2*size)
+	}
+}
+
+func TestRegistryKeepsDroppedBookWhileHeld(t *testing.T) {
+	dir := t.TempDir()
+	size := writeOpeningBooks(t, dir)
+	r := NewRegistry(dir, BookModeMemory, size)
+	start := fenAfter(t)
+
+	held, err := r.acquire("A.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch := watchClose(r, held)
+
+	// B takes A's place in the cache while A is still being read
+	if _, err := r.Moves(start, "B.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if got := cachedNames(r); !reflect.DeepEqual(got, []string{"B.bin"}) {
+		t.Fatalf("cached got %v, want only B.bin", got)
+	}
+	if watch.closed.Load() {
+		t.Fatal("a dropped book was closed while a lookup held it")
+	}
+	moves, err := findMoves(held.book, start)
+	if err != nil || len(moves) != 2 {
+		t.Errorf("held book got %v, %v, want its 2 moves", moves, err)
+	}
+
+	r.release(held)
+	if !watch.closed.Load() {
+		t.Error("a dropped book was not closed once its last lookup ended")
+	}
+}
+
+func TestRegistryReloadsChangedBook(t *testing.T) {
+	for _, mode := range []string{BookModeMemory, BookModeMapped} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			writeOpeningBooks(t, dir)
+			r := NewRegistry(dir, mode, 0)
+			start := fenAfter(t)
+
+			held, err := r.acquire("A.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			watch := watchClose(r, held)
+
+			// WritePolyglotFile renames the new book over the old one
+			writeBook(t, dir, "A.bin", "", []chess.PolyglotEntry{bookEntry(t, nil, "c2c4", 4)})
+			expireChecks(r)
+			moves, err := r.Moves(start, "A.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []BookMove{{Move: "c2c4", Weight: 4}}; !reflect.DeepEqual(moves, want) {
+				t.Errorf("after the rename got %v, want %v", moves, want)
+			}
+
+			// the old book, mapped or not, still answers the lookup holding it
+			if moves, err := findMoves(held.book, start); err != nil || len(moves) != 2 {
+				t.Errorf("old book got %v, %v, want its 2 moves", moves, err)
+			}
+			r.release(held)
+			if !watch.closed.Load() {
+				t.Error("the replaced book was not closed")
+			}
+
+			// same size, only the modification time tells it apart
+			writeBook(t, dir, "A.bin", "", []chess.PolyglotEntry{bookEntry(t, nil, "g1f3", 5)})
+			later := time.Now().Add(time.Minute)
+			if err := os.Chtimes(filepath.Join(dir, "A.bin"), later, later); err != nil {
+				t.Fatal(err)
+			}
+			expireChecks(r)
+			moves, err = r.Moves(start, "A.bin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []BookMove{{Move: "g1f3", Weight: 5}}; !reflect.DeepEqual(moves, want) {
+				t.Errorf("after touching got %v, want %v", moves, want)
+			}
+		})
+	}
+}
+
+func TestRegistryConcurrentMoves(t *testing.T) {
+	for _, mode := range []string{BookModeMemory, BookModeMapped} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			size := writeOpeningBooks(t, dir)
+			// room for one parsed book, so lookups keep dropping each other's
+			r := NewRegistry(dir, mode, size)
+			start := fenAfter(t)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 64)
+			for i := 0; i < 64; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					name := []string{"A.bin", "B.bin", "C.bin"}[i%3]
+					moves, err := r.Moves(start, name)
+					if err == nil && (len(moves) != 2 || moves[0].Weight != uint16(i%3+1)) {
+						err = fmt.Errorf("%s got %v", name, moves)
+					}
+					if i%8 == 0 {
+						expireChecks(r)
+					}
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMoveConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "books"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeOpeningBooks(t, filepath.Join(dir, "books"))
+	// GetMove looks in ./books through the default registry
+	t.Chdir(dir)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			moveData, err := GetMove("", []string{}, []string{"A.bin", "B.bin", "C.bin"}[i%3])
+			if err == nil && moveData.CoordinateMove != "e2e4" && moveData.CoordinateMove != "d2d4" {
+				err = fmt.Errorf("got %+v, want a book move", moveData)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"strings"
 	"time"
 
-	chess "github.com/corentings/chess/v2"
 	"github.com/thinktt/yowking/internal/books"
 )
 
@@ -227,6 +226,11 @@ func runFens(baseDir string) error {
 	return nil
 }
 
+// memStartFen is looked up in every book to time each mode.
+const memStartFen = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// runMem opens every book parsed into memory and then memory mapped, and
+// prints what each mode costs.
 func runMem(baseDir string) error {
 	absBooksDir := filepath.Join(baseDir, "books")
 	entries, err := os.ReadDir(absBooksDir)
@@ -234,57 +238,68 @@ func runMem(baseDir string) error {
 		return fmt.Errorf("read books dir: %w", err)
 	}
 
-	type loadedBook struct {
-		name string
-		book *chess.PolyglotBook
-	}
-	loaded := make([]loadedBook, 0)
+	names := make([]string, 0)
 	var diskBytes int64
-
-	runtime.GC()
-	var before runtime.MemStats
-	runtime.ReadMemStats(&before)
-
 	for _, e := range entries {
 		if e.IsDir() || !strings.HasSuffix(strings.ToLower(e.Name()), ".bin") {
 			continue
 		}
-		p := filepath.Join(absBooksDir, e.Name())
 		info, err := e.Info()
 		if err != nil {
-			return fmt.Errorf("stat %s: %w", p, err)
+			return fmt.Errorf("stat %s: %w", filepath.Join(absBooksDir, e.Name()), err)
 		}
 		diskBytes += info.Size()
+		names = append(names, e.Name())
+	}
+	if len(names) == 0 {
+		return errors.New("no .bin books loaded")
+	}
+	fmt.Printf("books=%d disk_bytes=%d disk_mb=%.2f\n", len(names), diskBytes, float64(diskBytes)/(1024*1024))
 
-		f, err := os.Open(p)
-		if err != nil {
-			return fmt.Errorf("open %s: %w", p, err)
-		}
-		b, err := chess.LoadFromReader(f)
-		f.Close()
-		if err != nil {
-			return fmt.Errorf("load %s: %w", p, err)
+	for _, mode := range []string{books.BookModeMemory, books.BookModeMapped} {
+		if err := memReport(absBooksDir, mode, names); err != nil {
+			return err
 		}
-		loaded = append(loaded, loadedBook{name: e.Name(), book: b})
+	}
+	return nil
+}
+
+func memReport(absBooksDir, mode string, names []string) error {
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	registry := books.NewRegistry(absBooksDir, mode, 0)
+	if err := registry.LoadAll(); err != nil {
+		return fmt.Errorf("load books (%s): %w", mode, err)
 	}
 
 	runtime.GC()
 	var after runtime.MemStats
 	runtime.ReadMemStats(&after)
 
+	start := time.Now()
+	for _, name := range names {
+		if _, err := registry.Moves(memStartFen, name); err != nil {
+			return fmt.Errorf("look up %s (%s): %w", name, mode, err)
+		}
+	}
+	lookupMS := safeDiv(msSince(start), float64(len(names)))
+
 	heapDelta := memDelta(after.HeapAlloc, before.HeapAlloc)
 	allocDelta := memDelta(after.Alloc, before.Alloc)
 	sysDelta := memDelta(after.Sys, before.Sys)
+	stats := registry.Stats()
 
-	fmt.Printf("books=%d disk_bytes=%d disk_mb=%.2f\n", len(loaded), diskBytes, float64(diskBytes)/(1024*1024))
-	fmt.Printf("heap_alloc_delta=%d (%.2f MB)\n", heapDelta, float64(heapDelta)/(1024*1024))
-	fmt.Printf("alloc_delta=%d (%.2f MB)\n", allocDelta, float64(allocDelta)/(1024*1024))
-	fmt.Printf("sys_delta=%d (%.2f MB)\n", sysDelta, float64(sysDelta)/(1024*1024))
+	fmt.Printf("mode=%s\n", mode)
+	fmt.Printf("  heap_alloc_delta=%d (%.2f MB)\n", heapDelta, float64(heapDelta)/(1024*1024))
+	fmt.Printf("  alloc_delta=%d (%.2f MB)\n", allocDelta, float64(allocDelta)/(1024*1024))
+	fmt.Printf("  sys_delta=%d (%.2f MB)\n", sysDelta, float64(sysDelta)/(1024*1024))
+	fmt.Printf("  mapped_bytes=%d (%.2f MB)\n", stats.Mapped, float64(stats.Mapped)/(1024*1024))
+	fmt.Printf("  first_lookup_avg_ms=%.4f\n", lookupMS)
 
-	// Keep parsed books alive until after stats are printed.
-	if len(loaded) == 0 {
-		return errors.New("no .bin books loaded")
-	}
+	// Keep the books open until after stats are printed.
+	runtime.KeepAlive(registry)
 	return nil
 }
 
@@ -0,0 +1,99 @@
+// Package dlq holds the move requests kingworker gave up on, in the
+// move-req-dlq JetStream stream, so they can be inspected and replayed.
+package dlq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/thinktt/yowking/pkg/models"
+)
+
+const (
+	StreamName = "move-req-dlq"
+	Subject    = "move-req-dlq"
+)
+
+// Record is a dead-lettered move request.
+type Record struct {
+	// Payload is the move-req message exactly as it arrived, which may not
+	// be valid JSON.
+	Payload  []byte            `json:"payload"`
+	Error    *models.MoveError `json:"error"`
+	Attempts uint64            `json:"attempts"`
+	// StreamSeq is the request's sequence in move-req-stream.
+	StreamSeq uint64    `json:"streamSeq"`
+	FailedAt  time.Time `json:"failedAt"`
+	Worker    string    `json:"worker,omitempty"`
+}
+
+// AddStream creates the dead-letter stream if it doesn't exist.
+func AddStream(js nats.JetStreamContext) error {
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:     StreamName,
+		Subjects: []string{Subject},
+	})
+	return err
+}
+
+// Publish writes a record to the dead-letter stream.
+func Publish(js nats.JetStreamContext, record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = js.Publish(Subject, data)
+	return err
+}
+
+// Entry is a record with its sequence in the dead-letter stream.
+type Entry struct {
+	Seq uint64 `json:"seq"`
+	Record
+}
+
+// List returns every record in the dead-letter stream, oldest first.
+func List(js nats.JetStreamContext) ([]Entry, error) {
+	info, err := js.StreamInfo(StreamName)
+	if err != nil {
+		return nil, fmt.Errorf("stream info %s: %w", StreamName, err)
+	}
+
+	entries := make([]Entry, 0, info.State.Msgs)
+	if info.State.Msgs == 0 {
+		return entries, nil
+	}
+	for seq := info.State.FirstSeq; seq <= info.State.LastSeq; seq++ {
+		entry, err := Get(js, seq)
+		if errors.Is(err, nats.ErrMsgNotFound) {
+			// deleted by a replay
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Get reads one record from the dead-letter stream.
+func Get(js nats.JetStreamContext, seq uint64) (Entry, error) {
+	msg, err := js.GetMsg(StreamName, seq)
+	if err != nil {
+		return Entry{}, fmt.Errorf("get %s seq %d: %w", StreamName, seq, err)
+	}
+	entry := Entry{Seq: seq}
+	if err := json.Unmarshal(msg.Data, &entry.Record); err != nil {
+		return Entry{}, fmt.Errorf("parse %s seq %d: %w", StreamName, seq, err)
+	}
+	return entry, nil
+}
+
+// Delete removes a record from the dead-letter stream.
+func Delete(js nats.JetStreamContext, seq uint64) error {
+	return js.DeleteMsg(StreamName, seq)
+}
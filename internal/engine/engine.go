@@ -3,95 +3,190 @@ package engine
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
-	"os"
-	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	chess "github.com/corentings/chess/v2"
 	"github.com/sirupsen/logrus"
+	"github.com/thinktt/yowking/internal/books"
 	"github.com/thinktt/yowking/pkg/models"
 )
 
 type MoveData = models.MoveData
 type Settings = models.MoveReq
 
-var isVerboseMode = false
 var logger = logrus.New()
-var log *logrus.Entry
 
-func GetMove(settings Settings) (MoveData, error) {
-	// fmt.Println(settings)
-	log = logger.WithFields(logrus.Fields{
-		"gameId": settings.GameId,
-		"moveNo": len(settings.Moves),
-	})
-	isVerboseMode = strings.EqualFold(os.Getenv("SHOULD_LOG_ENGINE"), "true")
+// timeoutGrace is added on top of the clock so a slow wine start or a busy
+// host doesn't get mistaken for a hung engine.
+const timeoutGrace = 10 * time.Second
 
-	isWsl := strings.EqualFold(os.Getenv("IS_WSL"), "true")
-	// shouldPostInput := os.Getenv("SHOULD_POST_INPUT")
-	// log.Println("shouldPostInput: " + shouldPostInput)
+// TimeoutError is returned when the engine produced neither a move nor a
+// usable post line before its deadline.
+type TimeoutError struct {
+	ClockTime int
+	Timeout   time.Duration
+}
 
-	var cmd *exec.Cmd
-	if isWsl {
-		cmd = exec.Command("./TheKing350.exe")
-	} else {
-		cmd = exec.Command("wine", "enginewrap.exe")
-	}
+// searchTimeouts counts searches whose deadline passed, see Timeouts.
+var searchTimeouts atomic.Uint64
 
-	engine, err := cmd.StdinPipe()
-	if err != nil {
-		log.Println(err)
-		return MoveData{}, err
-	}
+// Timeouts is how many searches have run past their deadline since the
+// process started, including those answered from the engine's best post
+// line rather than failed with a *TimeoutError.
+func Timeouts() uint64 {
+	return searchTimeouts.Load()
+}
 
-	engineOut, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Println(err)
-		return MoveData{}, err
-	}
+// ErrStopped is the cause to cancel a search's ctx with, see
+// context.WithCancelCause, to end it early and take the engine's best post
+// line so far, as a timeout does.
+var ErrStopped = errors.New("search stopped")
 
-	engineErr, err := cmd.StderrPipe()
-	if err != nil {
-		log.Println(err)
-		return MoveData{}, err
-	}
+func stopped(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrStopped)
+}
 
-	moveChan := make(chan MoveData)
-	errChan := make(chan error)
-	defer close(moveChan)
-	defer close(errChan)
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("engine gave no move within %s (clockTime %d)", e.Timeout, e.ClockTime)
+}
 
-	// handle the engine streams in real time
-	go readEngineOut(engineOut, moveChan, settings.StopId)
-	go readEngineErrs(engineErr)
-	go forwardUserCommands(engine)
+// MoveTimeout is the longest a search on the given clock may take. The clock
+// is sent to the King as xboard `time`, which is in centiseconds, so the
+// engine should never think longer than the whole clock.
+func MoveTimeout(clockTime int) time.Duration {
+	return time.Duration(clockTime)*10*time.Millisecond + timeoutGrace
+}
 
-	// start the engine
-	err = cmd.Start()
-	if err != nil {
-		errToGo := fmt.Errorf("cmd.Run() failed with %s", err)
-		return MoveData{}, errToGo
+// ThinkFunc is called with each post (or UCI info) line the engine sends
+// while it searches, in order and from the search's own goroutine, so it
+// should not block.
+type ThinkFunc func(MoveData)
+
+type thinkKey struct{}
+
+// WithThinking returns a ctx whose searches report their post lines to
+// think as well as keeping them for a timeout. Lines stop when GetMove
+// returns.
+func WithThinking(ctx context.Context, think ThinkFunc) context.Context {
+	return context.WithValue(ctx, thinkKey{}, think)
+}
+
+func thinkingFrom(ctx context.Context) ThinkFunc {
+	think, _ := ctx.Value(thinkKey{}).(ThinkFunc)
+	return think
+}
+
+// Engine finds a move for the position and personality in settings. Pool is
+// the engine process implementation, speaking xboard to the King or UCI.
+type Engine interface {
+	GetMove(ctx context.Context, settings Settings) (MoveData, error)
+	Close()
+}
+
+var (
+	defaultEngine     *router
+	defaultEngineOnce sync.Once
+	// minPoolSize is the smallest size the default pools get, see
+	// EnsurePoolSize
+	minPoolSize int
+)
+
+// EnsurePoolSize makes the default pools keep at least n processes, so n
+// searches can run at once without waiting on each other. It has no effect
+// once Default has been called.
+func EnsurePoolSize(n int) {
+	minPoolSize = n
+}
+
+// Default returns the shared engines configured from the environment: the
+// King pool (see PoolConfigFromEnv) and, when UCI_ENG_CMD or UCI_ENG_CONFIG
+// is set, a UCI pool of the same size. A bad engine config is fatal, so
+// workers should call it once at startup.
+func Default() Engine {
+	defaultEngineOnce.Do(func() {
+		cfg, err := PoolConfigFromEnv()
+		if err != nil {
+			logger.Fatalf("engine config: %v", err)
+		}
+		if cfg.Size < minPoolSize {
+			cfg.Size = minPoolSize
+		}
+		logger.Printf("engine pool size %d, launching: %s %s", cfg.Size, cfg.Launch.Command, strings.Join(cfg.Launch.Args, " "))
+		defaultEngine = &router{king: NewPool(cfg)}
+
+		uciLaunch, ok, err := UciLaunchConfigFromEnv()
+		if err != nil {
+			logger.Fatalf("uci engine config: %v", err)
+		}
+		if ok {
+			uciCfg := cfg
+			uciCfg.Protocol = ProtocolUci
+			uciCfg.Launch = uciLaunch
+			logger.Printf("uci engine pool size %d, launching: %s %s", uciCfg.Size, uciLaunch.Command, strings.Join(uciLaunch.Args, " "))
+			defaultEngine.uci = NewPool(uciCfg)
+		}
+	})
+	return defaultEngine
+}
+
+// GetMove runs the search on the default engines. The search is bounded by
+// ctx and by MoveTimeout(settings.ClockTime). When the deadline passes the
+// engine process tree is killed and the best move seen in the post lines is
+// returned, or a *TimeoutError if there was none. Returned errors are
+// *models.MoveError wrapping the cause, e.g. ENGINE_TIMEOUT for a
+// *TimeoutError; an engine complaint comes back in MoveData.Error.
+func GetMove(ctx context.Context, settings Settings) (MoveData, error) {
+	return Default().GetMove(ctx, settings)
+}
+
+// Shutdown quits every default engine, waiting for searches in progress to
+// finish.
+func Shutdown() {
+	defaultEngineOnce.Do(func() {})
+	if defaultEngine != nil {
+		defaultEngine.Close()
 	}
+}
 
-	// from here if getMove() errors or completes be sure to stop the engine
-	defer func() {
-		go stopEngine(engine, cmd, log)
-	}()
+// router sends each search to the King pool, or to the UCI pool for
+// personalities whose Engine is models.EngineUci.
+type router struct {
+	king *Pool
+	uci  *Pool
+}
 
-	if settings.RandomIsOff {
-		settings.CmpVals.Rnd = "0"
-		log.Info("randomIsOff is set, setting cmp rnd val to 0")
+func (r *router) GetMove(ctx context.Context, settings Settings) (MoveData, error) {
+	switch settings.Engine {
+	case "", models.EngineKing:
+		return r.king.GetMove(ctx, settings)
+	case models.EngineUci:
+		if r.uci == nil {
+			return MoveData{}, models.NewMoveError(models.ErrEngineUnavailable, "no uci engine configured, set UCI_ENG_CMD or UCI_ENG_CONFIG")
+		}
+		return r.uci.GetMove(ctx, settings)
+	default:
+		return MoveData{}, models.NewMoveError(models.ErrEngineUnavailable, fmt.Sprintf("unknown engine %q", settings.Engine))
 	}
+}
 
-	// log all the cmpVals with keys
-	// fmt.Printf("%+v\n", settings.CmpVals)
+func (r *router) Close() {
+	r.king.Close()
+	if r.uci != nil {
+		r.uci.Close()
+	}
+}
 
-	// prepare all the personality setting commands to be sent to the engine
-	cmpLoaderTemplate := `cm_parm default
+// prepare all the personality setting commands to be sent to the engine
+var cmpLoader = template.Must(template.New("pValsTemplate").Parse(`cm_parm default
 	cm_parm opp={{.Opp}} opn={{.Opn}} opb={{.Opb}} opr={{.Opr}} opq={{.Opq}}
 	cm_parm myp={{.Myp}} myn={{.Myn}} myb={{.Myb}} myr={{.Myr}} myq={{.Myq}}
 	cm_parm mycc={{.Mycc}} mymob={{.Mymob}} myks={{.Myks}}  mypp={{.Mypp}} mypw={{.Mypw}}
@@ -99,77 +194,178 @@ func GetMove(settings Settings) (MoveData, error) {
 	cm_parm cfd={{.Cfd}} sop={{.Sop}} avd={{.Avd}} rnd={{.Rnd}} sel={{.Sel}} md={{.Md}}
 	cm_parm tts={{.Tts}}
 	easy
-	`
-	t := template.Must(template.New("pValsTemplate").Parse(cmpLoaderTemplate))
+	`))
+
+// search resets the process to a new game, loads the settings and waits for
+// the King's move. reusable reports whether the King finished cleanly and
+// can be handed the next request.
+func (p *process) search(ctx context.Context, settings Settings, log *logrus.Entry) (moveData MoveData, reusable bool, err error) {
+	if settings.RandomIsOff {
+		settings.CmpVals.Rnd = "0"
+		log.Info("randomIsOff is set, setting cmp rnd val to 0")
+	}
+
 	buf := &bytes.Buffer{}
-	if err := t.Execute(buf, settings.CmpVals); err != nil {
-		return MoveData{}, err
+	if err := cmpLoader.Execute(buf, settings.CmpVals); err != nil {
+		return MoveData{}, true, err
 	}
 
 	// log.Println("clockTime: ", settings.ClockTime)
 	timeStr := fmt.Sprintf("time %d\n", settings.ClockTime)
 	otimStr := fmt.Sprintf("otim %d\n", settings.ClockTime)
 
-	// send settings to the engine
-	engine.Write([]byte("xboard\n"))
-	engine.Write([]byte("post\n"))
-	engine.Write([]byte(timeStr))
-	engine.Write([]byte(otimStr))
-	engine.Write(buf.Bytes())
+	// send settings to the engine, new resets whatever the last game left
+	p.write("new\n")
+	if settings.StartFen != "" {
+		p.write(fmt.Sprintf("setboard %s\n", settings.StartFen))
+	}
+	p.write("post\n")
+	p.write(timeStr)
+	p.write(otimStr)
+	p.write(buf.String())
 
 	// send all the moves to the engine
 	for _, move := range settings.Moves {
-		moveStr := fmt.Sprintf("%s\n", move)
-		engine.Write([]byte(moveStr))
+		p.write(fmt.Sprintf("%s\n", move))
 	}
 
+	// start the engine
+	p.write("go\n")
+
+	timeout := MoveTimeout(settings.ClockTime)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// buffered so the reader can always deliver and exit, even after a timeout
+	type result struct {
+		moveData MoveData
+		idle     bool
+	}
+	resultChan := make(chan result, 1)
+	best := &searchState{think: thinkingFrom(ctx)}
+	defer best.stopThinking()
+	go func() {
+		moveData, idle := readEngineOut(p.lines, best, settings.StopId, p.verbose, log)
+		resultChan <- result{moveData, idle}
+	}()
+
+	// wait for the engine to send back a move
 	select {
-	case moveData := <-moveChan:
-		// having move Data right now means the engine didn't like the settings
-		return moveData, nil
-	default:
-		log.Println("engine accepted the settings witouth error")
+	case res := <-resultChan:
+		return res.moveData, res.idle && res.moveData.Err == nil, nil
+	case <-ctx.Done():
 	}
 
-	// start the engine
-	engine.Write([]byte("go\n"))
+	if stopped(ctx) {
+		// the King can't be told to stop, only killed
+		log.Println("search stopped, killing engine")
+		moveData, err = best.move(settings.StartFen, settings.Moves)
+		if err != nil {
+			log.Error("no usable post line: ", err)
+			return MoveData{}, false, ErrStopped
+		}
+		log.Println("using best post line move:", moveData.AlgebraMove)
+		return moveData, false, nil
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Println("move request canceled, killing engine")
+		return MoveData{}, false, ctx.Err()
+	}
 
-	// wait for the engine to send back a move
-	moveData := <-moveChan
+	searchTimeouts.Add(1)
+	log.Errorf("engine gave no move within %s, killing engine", timeout)
+	moveData, err = best.move(settings.StartFen, settings.Moves)
+	if err != nil {
+		log.Error("no usable post line: ", err)
+		return MoveData{}, false, &TimeoutError{ClockTime: settings.ClockTime, Timeout: timeout}
+	}
+	log.Println("using best post line move:", moveData.AlgebraMove)
+	return moveData, false, nil
+}
+
+// searchState holds the latest post line so a timed out search can still
+// answer with the engine's current best move. Each line is also passed to
+// think, if set, until the search ends.
+type searchState struct {
+	mu    sync.Mutex
+	last  MoveData
+	think ThinkFunc
+}
+
+func (s *searchState) set(moveData MoveData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = moveData
+	if s.think != nil {
+		s.think(moveData)
+	}
+}
+
+// stopThinking stops passing lines to think, so none arrive after the
+// search has returned its move.
+func (s *searchState) stopThinking() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.think = nil
+}
+
+func (s *searchState) latest() MoveData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// move returns the latest post line with its SAN move translated to the
+// coordinate move the rest of yowking expects.
+func (s *searchState) move(startFen string, moves []string) (MoveData, error) {
+	moveData := s.latest()
+	if moveData.AlgebraMove == "" {
+		return MoveData{}, errors.New("engine posted no moves")
+	}
+	coordinateMove, err := sanToCoordinate(startFen, moves, moveData.AlgebraMove)
+	if err != nil {
+		return MoveData{}, err
+	}
+	moveData.CoordinateMove = coordinateMove
 	return moveData, nil
 }
 
-func stopEngine(engine io.WriteCloser, cmd *exec.Cmd, log *logrus.Entry) {
-	// send a quilt command to the engine
-	engine.Write([]byte("quit\n"))
-	engine.Close()
-	cmd.Wait()
-	log.Println("engine closed")
+// sanToCoordinate plays the move list from startFen and converts the King's
+// SAN move for the resulting position into a coordinate move.
+func sanToCoordinate(startFen string, moves []string, san string) (string, error) {
+	g, err := books.PlayMoves(startFen, moves)
+	if err != nil {
+		return "", err
+	}
+	pos := g.Position()
+	m, err := chess.AlgebraicNotation{}.Decode(pos, san)
+	if err != nil {
+		return "", fmt.Errorf("decode engine move %q: %w", san, err)
+	}
+	return chess.UCINotation{}.Encode(pos, m), nil
 }
 
-func readEngineOut(r io.Reader, moveChan chan MoveData, stopId int) {
-	s := bufio.NewScanner(r)
+// readEngineOut consumes engine lines until the search ends. idle reports
+// whether the engine ended the search with its own move line, meaning it is
+// no longer thinking.
+func readEngineOut(lines <-chan string, best *searchState, stopId int, verbose bool, log *logrus.Entry) (MoveData, bool) {
 	moveCandidate := MoveData{}
 
-	for s.Scan() {
-		engineLine := s.Text()
-		if isVerboseMode {
+	for engineLine := range lines {
+		if verbose {
 			log.Println(engineLine)
 		}
 
 		// if the engine finds a setting error send empty move response with an error
-		if strings.Contains(engineLine, "Error") ||
-			strings.Contains(engineLine, "Illegal") {
-			errStr := "callout by engine: " + engineLine
-			moveCandidate = MoveData{Err: &errStr}
-			break
+		if code, ok := calloutCode(engineLine); ok {
+			return calloutMoveData(code, engineLine), false
 		}
 
 		// check if the engine line final move result
 		words := strings.Fields(engineLine)
 		if strings.Contains(engineLine, "move") && len(words) == 2 {
 			moveCandidate.CoordinateMove = strings.Fields(engineLine)[1]
-			break
+			return moveCandidate, true
 		}
 
 		// parse the enginLine if it is a move line
@@ -178,19 +374,61 @@ func readEngineOut(r io.Reader, moveChan chan MoveData, stopId int) {
 			continue
 		}
 		moveCandidate = moveData
+		best.set(moveData)
 
 		// if the move line is the stopId move line, break and send this move
 		if moveData.Id == stopId {
 			log.Println("engine found stopId, move:", moveData.AlgebraMove)
-			break
+			return moveCandidate, false
 		}
 	}
 
-	// moveCandidate.Err = errStr
-	moveChan <- moveCandidate
+	return models.ErrMoveData(models.NewMoveError(models.ErrEngineCrash, "engine exited before sending a move")), false
+}
+
+// calloutCode recognizes the King complaining about what it was sent,
+// "Illegal move: ..." or "Error (...): ...", and gives it an error code.
+// Neither is retryable, as the same request gets the same complaint.
+func calloutCode(engineLine string) (string, bool) {
+	switch {
+	case strings.HasPrefix(engineLine, "Illegal"):
+		return models.ErrIllegalMove, true
+	case strings.HasPrefix(engineLine, "Error"):
+		return models.ErrEngineRejected, true
+	}
+	return "", false
+}
+
+// calloutMoveData reports an engine line complaining about what it was sent.
+func calloutMoveData(code, engineLine string) MoveData {
+	return models.ErrMoveData(models.NewMoveError(code, "callout by engine: "+engineLine))
+}
+
+// asMoveError gives an engine failure its models.MoveError code, keeping the
+// original error reachable through errors.Is and errors.As.
+func asMoveError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var moveErr *models.MoveError
+	if errors.As(err, &moveErr) {
+		return err
+	}
+
+	var timeoutErr *TimeoutError
+	code := models.ErrEngineCrash
+	switch {
+	case errors.As(err, &timeoutErr):
+		code = models.ErrEngineTimeout
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrStopped):
+		code = models.ErrCanceled
+	case errors.Is(err, ErrPoolClosed):
+		code = models.ErrEngineUnavailable
+	}
+	return models.WrapMoveError(code, err)
 }
 
-func readEngineErrs(r io.Reader) {
+func readEngineErrs(r io.Reader, log *logrus.Entry) {
 	s := bufio.NewScanner(r)
 	for s.Scan() {
 		engineLine := s.Text()
@@ -224,11 +462,3 @@ func parseMoveLine(words []string) (MoveData, error) {
 
 	return moveData, nil
 }
-
-func forwardUserCommands(engine io.WriteCloser) {
-	s := bufio.NewScanner(os.Stdin)
-	for s.Scan() {
-		line := s.Text()
-		engine.Write([]byte(line + "\n"))
-	}
-}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// LaunchConfig describes how to start an engine process.
+type LaunchConfig struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// Dir is the working directory, empty for the worker's own.
+	Dir string `json:"dir"`
+	// Env is added on top of the passed through environment, e.g. WINEPREFIX.
+	Env map[string]string `json:"env"`
+	// PassEnv limits which worker variables the engine sees, empty passes
+	// the whole environment through.
+	PassEnv []string `json:"passEnv"`
+}
+
+// kingLaunch runs the King under wine, or directly when IS_WSL is set.
+func kingLaunch() LaunchConfig {
+	if strings.EqualFold(os.Getenv("IS_WSL"), "true") {
+		return LaunchConfig{Command: "./TheKing350.exe"}
+	}
+	return LaunchConfig{Command: "wine", Args: []string{"enginewrap.exe"}}
+}
+
+// LaunchConfigFromEnv reads the King launch config from the JSON file named
+// by ENG_CONFIG, or else from ENG_CMD, a command line split on whitespace.
+// With neither set the King runs under wine as before.
+func LaunchConfigFromEnv() (LaunchConfig, error) {
+	return loadLaunchConfig("ENG_CMD", "ENG_CONFIG", kingLaunch())
+}
+
+// UciLaunchConfigFromEnv reads the UCI engine launch config from
+// UCI_ENG_CONFIG or UCI_ENG_CMD. ok is false when neither is set.
+func UciLaunchConfigFromEnv() (cfg LaunchConfig, ok bool, err error) {
+	cfg, err = loadLaunchConfig("UCI_ENG_CMD", "UCI_ENG_CONFIG", LaunchConfig{})
+	return cfg, cfg.Command != "", err
+}
+
+func loadLaunchConfig(cmdVar, configVar string, fallback LaunchConfig) (LaunchConfig, error) {
+	if path := os.Getenv(configVar); path != "" {
+		return readLaunchConfig(path)
+	}
+
+	if fields := strings.Fields(os.Getenv(cmdVar)); len(fields) > 0 {
+		return LaunchConfig{Command: fields[0], Args: fields[1:]}, nil
+	}
+
+	return fallback, nil
+}
+
+func readLaunchConfig(path string) (LaunchConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return LaunchConfig{}, fmt.Errorf("read engine config %s: %w", path, err)
+	}
+	var cfg LaunchConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return LaunchConfig{}, fmt.Errorf("parse engine config %s: %w", path, err)
+	}
+	if cfg.Command == "" {
+		return LaunchConfig{}, fmt.Errorf("engine config %s: command is required", path)
+	}
+	return cfg, nil
+}
+
+// Cmd builds the engine command described by the config.
+func (c LaunchConfig) Cmd() *exec.Cmd {
+	cmd := exec.Command(c.Command, c.Args...)
+	cmd.Dir = c.Dir
+
+	var env []string
+	if len(c.PassEnv) == 0 {
+		env = os.Environ()
+	} else {
+		for _, name := range c.PassEnv {
+			if value, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+value)
+			}
+		}
+	}
+	for name, value := range c.Env {
+		env = append(env, name+"="+value)
+	}
+	cmd.Env = env
+
+	return cmd
+}
@@ -0,0 +1,317 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrPoolClosed is returned by GetMove once the pool has been closed.
+var ErrPoolClosed = errors.New("engine pool closed")
+
+// quitGrace is how long a King gets to honor quit before it is killed.
+const quitGrace = 5 * time.Second
+
+// Protocols a pool can speak to its engines.
+const (
+	ProtocolXboard = "xboard"
+	ProtocolUci    = "uci"
+)
+
+// PoolConfig sizes an engine pool.
+type PoolConfig struct {
+	// Protocol is ProtocolXboard (the King's dialect, the default) or
+	// ProtocolUci.
+	Protocol string
+	// Size is the number of King processes kept alive.
+	Size int
+	// MaxMoves recycles a process after it has played this many moves, 0 never
+	// recycles a healthy process.
+	MaxMoves int
+	// Launch starts each process, the zero value runs the King under wine.
+	// Point it at cmd/fakeking to run without wine.
+	Launch LaunchConfig
+	// Verbose logs every line the engines send.
+	Verbose bool
+}
+
+// PoolConfigFromEnv reads ENGINE_POOL_SIZE and ENGINE_MAX_MOVES, defaulting
+// to a single King recycled every 100 moves, SHOULD_LOG_ENGINE and the launch
+// config (see LaunchConfigFromEnv).
+func PoolConfigFromEnv() (PoolConfig, error) {
+	cfg := PoolConfig{Size: 1, MaxMoves: 100}
+	cfg.Verbose = strings.EqualFold(os.Getenv("SHOULD_LOG_ENGINE"), "true")
+	if n, err := strconv.Atoi(os.Getenv("ENGINE_POOL_SIZE")); err == nil && n > 0 {
+		cfg.Size = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("ENGINE_MAX_MOVES")); err == nil && n >= 0 {
+		cfg.MaxMoves = n
+	}
+
+	launch, err := LaunchConfigFromEnv()
+	if err != nil {
+		return PoolConfig{}, err
+	}
+	cfg.Launch = launch
+	return cfg, nil
+}
+
+// Pool keeps warm King processes so a move request doesn't pay for wine
+// startup. Each process serves one search at a time; processes are started
+// lazily, reset with new/cm_parm default between requests, and replaced after
+// MaxMoves moves or after any error.
+type Pool struct {
+	cfg PoolConfig
+	// slots holds one entry per process, nil until a process is started
+	slots   chan *process
+	closing chan struct{}
+	once    sync.Once
+}
+
+// NewPool creates a pool, no process is started until the first move.
+func NewPool(cfg PoolConfig) *Pool {
+	if cfg.Size <= 0 {
+		cfg.Size = 1
+	}
+	if cfg.Protocol == "" {
+		cfg.Protocol = ProtocolXboard
+	}
+	if cfg.Launch.Command == "" {
+		cfg.Launch = kingLaunch()
+	}
+	p := &Pool{
+		cfg:     cfg,
+		slots:   make(chan *process, cfg.Size),
+		closing: make(chan struct{}),
+	}
+	for i := 0; i < cfg.Size; i++ {
+		p.slots <- nil
+	}
+	return p
+}
+
+// GetMove waits for a free King and runs the search on it.
+func (p *Pool) GetMove(ctx context.Context, settings Settings) (MoveData, error) {
+	log := logger.WithFields(logrus.Fields{
+		"gameId": settings.GameId,
+		"moveNo": len(settings.Moves),
+	})
+
+	proc, err := p.acquire(ctx, log)
+	if err != nil {
+		return MoveData{}, asMoveError(err)
+	}
+
+	var moveData MoveData
+	var reusable bool
+	if p.cfg.Protocol == ProtocolUci {
+		moveData, reusable, err = proc.searchUci(ctx, settings, log)
+	} else {
+		moveData, reusable, err = proc.search(ctx, settings, log)
+	}
+	p.release(proc, reusable && err == nil, log)
+	return moveData, asMoveError(err)
+}
+
+// Close quits every process, waiting for searches in progress to finish.
+func (p *Pool) Close() {
+	p.once.Do(func() {
+		close(p.closing)
+		for i := 0; i < p.cfg.Size; i++ {
+			if proc := <-p.slots; proc != nil {
+				proc.quit()
+			}
+		}
+		logger.Println("engine pool closed")
+	})
+}
+
+func (p *Pool) acquire(ctx context.Context, log *logrus.Entry) (*process, error) {
+	var proc *process
+	select {
+	case <-p.closing:
+		return nil, ErrPoolClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case proc = <-p.slots:
+	}
+
+	select {
+	case <-p.closing:
+		p.slots <- proc
+		return nil, ErrPoolClosed
+	default:
+	}
+
+	if proc != nil && !proc.healthy() {
+		log.Println("engine failed health check, replacing it")
+		go proc.kill()
+		proc = nil
+	}
+	if proc != nil {
+		return proc, nil
+	}
+
+	proc, err := startProcess(p.cfg.Launch.Cmd(), p.cfg.Protocol)
+	if err != nil {
+		p.slots <- nil
+		return nil, err
+	}
+	proc.verbose = p.cfg.Verbose
+	log.Println("engine started")
+	return proc, nil
+}
+
+func (p *Pool) release(proc *process, reusable bool, log *logrus.Entry) {
+	proc.moves++
+	if reusable && (p.cfg.MaxMoves == 0 || proc.moves < p.cfg.MaxMoves) {
+		p.slots <- proc
+		return
+	}
+
+	if reusable {
+		log.Println("engine played", proc.moves, "moves, recycling it")
+		go proc.quit()
+	} else {
+		go proc.kill()
+	}
+	p.slots <- nil
+}
+
+// process is one running engine, its stdout split into lines.
+type process struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	lines chan string
+	// done is closed once the process has exited
+	done  chan struct{}
+	moves int
+	// uciDefaults and uciSet track UCI options so they can be reset
+	uciDefaults map[string]string
+	uciSet      map[string]string
+	// verbose logs every engine line, see PoolConfig.Verbose
+	verbose bool
+}
+
+func startProcess(cmd *exec.Cmd, protocol string) (*process, error) {
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &process{
+		cmd:   cmd,
+		stdin: stdin,
+		lines: make(chan string, 256),
+		done:  make(chan struct{}),
+	}
+
+	go readEngineErrs(stderr, logrus.NewEntry(logger))
+	go func() {
+		s := bufio.NewScanner(stdout)
+		for s.Scan() {
+			p.lines <- s.Text()
+		}
+		close(p.lines)
+		cmd.Wait()
+		close(p.done)
+	}()
+
+	if protocol == ProtocolUci {
+		if err := p.uciHandshake(); err != nil {
+			p.kill()
+			return nil, err
+		}
+		return p, nil
+	}
+
+	p.write("xboard\n")
+	return p, nil
+}
+
+func (p *process) write(s string) {
+	p.stdin.Write([]byte(s))
+}
+
+// healthy reports whether the process is still running and left nothing
+// alarming behind since its last search. Stale output is discarded.
+func (p *process) healthy() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return false
+			}
+			if _, ok := calloutCode(line); ok {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+}
+
+// drain discards output nobody is waiting for so the process can exit.
+func (p *process) drain() {
+	for range p.lines {
+	}
+}
+
+// quit asks the engine to exit and kills it if it doesn't.
+func (p *process) quit() {
+	go p.drain()
+	p.write("quit\n")
+	p.stdin.Close()
+	select {
+	case <-p.done:
+		logger.Println("engine closed")
+	case <-time.After(quitGrace):
+		p.kill()
+	}
+}
+
+// kill takes down wine, enginewrap and the King together, for engines that
+// can no longer be trusted to honor quit.
+func (p *process) kill() {
+	go p.drain()
+	p.stdin.Close()
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	if err := killProcessTree(p.cmd); err != nil {
+		logger.Error("failed to kill engine: ", err)
+	}
+	<-p.done
+	logger.Println("engine killed")
+}
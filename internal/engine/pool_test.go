@@ -0,0 +1,343 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thinktt/yowking/pkg/models"
+)
+
+// fakekingPath is cmd/fakeking, built once for the whole package.
+var fakekingPath string
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	dir, err := os.MkdirTemp("", "fakeking")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	fakekingPath = filepath.Join(dir, "fakeking")
+	build := exec.Command("go", "build", "-o", fakekingPath, "github.com/thinktt/yowking/cmd/fakeking")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "build fakeking:", err)
+		return 1
+	}
+
+	logger.SetOutput(io.Discard)
+	return m.Run()
+}
+
+// newTestPool starts a pool of fakekings running script, or answering go
+// with their first legal move when script is empty.
+func newTestPool(t *testing.T, cfg PoolConfig, script string) *Pool {
+	t.Helper()
+	cfg.Launch = LaunchConfig{Command: fakekingPath, PassEnv: []string{"PATH"}}
+	if cfg.Protocol == ProtocolUci {
+		cfg.Launch.Args = append(cfg.Launch.Args, "-uci")
+	}
+	if script != "" {
+		path := filepath.Join(t.TempDir(), "script")
+		if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg.Launch.Args = append(cfg.Launch.Args, "-script", path)
+	}
+
+	p := NewPool(cfg)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// slot returns the process a single process pool will hand out next, nil
+// when it will start a new one.
+func slot(p *Pool) *process {
+	proc := <-p.slots
+	p.slots <- proc
+	return proc
+}
+
+func moveErrCode(t *testing.T, err error) string {
+	t.Helper()
+	var moveErr *models.MoveError
+	if !errors.As(err, &moveErr) {
+		t.Fatalf("error %v is not a *models.MoveError", err)
+	}
+	return moveErr.Code
+}
+
+func TestGetMoveReadsPostLines(t *testing.T) {
+	p := newTestPool(t, PoolConfig{}, `
+on go
+post 1 12 3 101 e5
+post 2 10 8 102 Nc6
+move b8c6
+`)
+
+	var thinking []MoveData
+	ctx := WithThinking(context.Background(), func(moveData MoveData) {
+		thinking = append(thinking, moveData)
+	})
+	moveData, err := p.GetMove(ctx, Settings{Moves: []string{"e2e4"}, ClockTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := MoveData{Depth: 2, Eval: 10, Time: 8, Id: 102, AlgebraMove: "Nc6", CoordinateMove: "b8c6"}
+	if moveData != want {
+		t.Errorf("got %+v, want %+v", moveData, want)
+	}
+	if len(thinking) != 2 || thinking[0].AlgebraMove != "e5" || thinking[1].AlgebraMove != "Nc6" {
+		t.Errorf("thinking got %+v, want the e5 and Nc6 post lines", thinking)
+	}
+	if slot(p) == nil {
+		t.Error("an engine that answered with a move was not kept")
+	}
+}
+
+func TestGetMoveReportsCallouts(t *testing.T) {
+	tests := []struct {
+		line string
+		code string
+	}{
+		{"Illegal move: e2e5", models.ErrIllegalMove},
+		{"Error (bad fen): x", models.ErrEngineRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			p := newTestPool(t, PoolConfig{}, "on go\nsay "+tt.line+"\n")
+
+			moveData, err := p.GetMove(context.Background(), Settings{ClockTime: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if moveData.Error == nil || moveData.Error.Code != tt.code || moveData.Error.Retryable {
+				t.Fatalf("got %+v, want a %s error that isn't retryable", moveData, tt.code)
+			}
+			if moveData.Err == nil || *moveData.Err != "callout by engine: "+tt.line {
+				t.Errorf("err got %v, want the engine line", moveData.Err)
+			}
+			if slot(p) != nil {
+				t.Error("an engine that complained was kept")
+			}
+		})
+	}
+}
+
+func TestGetMoveIgnoresErrorInText(t *testing.T) {
+	p := newTestPool(t, PoolConfig{}, "on go\nsay telluser no Error here\nmove e2e4\n")
+
+	moveData, err := p.GetMove(context.Background(), Settings{ClockTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moveData.Error != nil || moveData.CoordinateMove != "e2e4" {
+		t.Errorf("got %+v, want e2e4", moveData)
+	}
+}
+
+func TestGetMoveTimeoutUsesBestPost(t *testing.T) {
+	p := newTestPool(t, PoolConfig{}, `
+on go
+post 4 25 10 201 O-O
+hang
+`)
+
+	// a Nf3 replayed as f2f3 would leave O-O illegal here
+	settings := Settings{Moves: []string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "f8c5"}, ClockTime: 100}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	timeouts := Timeouts()
+	moveData, err := p.GetMove(ctx, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moveData.CoordinateMove != "e1g1" || moveData.Id != 201 {
+		t.Errorf("got %+v, want post line 201 as e1g1", moveData)
+	}
+	if got := Timeouts() - timeouts; got != 1 {
+		t.Errorf("counted %d timeouts, want the rescued search counted once", got)
+	}
+	if slot(p) != nil {
+		t.Error("a hung engine was kept")
+	}
+}
+
+func TestGetMoveTimeoutWithoutPost(t *testing.T) {
+	p := newTestPool(t, PoolConfig{}, "on go\nhang\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err := p.GetMove(ctx, Settings{ClockTime: 100})
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a *TimeoutError", err)
+	}
+	if code := moveErrCode(t, err); code != models.ErrEngineTimeout {
+		t.Errorf("code got %s, want %s", code, models.ErrEngineTimeout)
+	}
+}
+
+func TestGetMoveStopped(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		move   string
+	}{
+		{"with a post line", "on go\npost 3 15 20 301 e5\nhang\n", "e7e5"},
+		{"without one", "on go\nhang\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPool(t, PoolConfig{}, tt.script)
+
+			ctx, cancel := context.WithCancelCause(context.Background())
+			time.AfterFunc(300*time.Millisecond, func() { cancel(ErrStopped) })
+			timeouts := Timeouts()
+			moveData, err := p.GetMove(ctx, Settings{Moves: []string{"e2e4"}, ClockTime: 100})
+			if tt.move == "" {
+				if !errors.Is(err, ErrStopped) || moveErrCode(t, err) != models.ErrCanceled {
+					t.Fatalf("got %+v, %v, want ErrStopped", moveData, err)
+				}
+			} else if err != nil || moveData.CoordinateMove != tt.move {
+				t.Fatalf("got %+v, %v, want %s", moveData, err, tt.move)
+			}
+			if Timeouts() != timeouts {
+				t.Error("a stopped search was counted as a timeout")
+			}
+			if slot(p) != nil {
+				t.Error("a stopped King was kept")
+			}
+		})
+	}
+}
+
+func TestGetMoveEngineExit(t *testing.T) {
+	p := newTestPool(t, PoolConfig{}, "on go\npost 1 0 0 1 e4\nexit\n")
+
+	moveData, err := p.GetMove(context.Background(), Settings{ClockTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moveData.Error == nil || moveData.Error.Code != models.ErrEngineCrash {
+		t.Fatalf("got %+v, want an %s error", moveData, models.ErrEngineCrash)
+	}
+	if slot(p) != nil {
+		t.Error("an engine that exited was kept")
+	}
+
+	// the next search gets a new engine
+	moveData, err = p.GetMove(context.Background(), Settings{ClockTime: 100})
+	if err != nil || moveData.Error == nil || moveData.Error.Code != models.ErrEngineCrash {
+		t.Errorf("second search got %+v, %v, want another %s error", moveData, err, models.ErrEngineCrash)
+	}
+}
+
+func TestGetMoveStopId(t *testing.T) {
+	p := newTestPool(t, PoolConfig{}, `
+on go
+post 1 12 3 101 e5
+post 2 10 8 102 Nc6
+sleep 10s
+move b8c6
+`)
+
+	start := time.Now()
+	moveData, err := p.GetMove(context.Background(), Settings{Moves: []string{"e2e4"}, StopId: 102, ClockTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moveData.Id != 102 || moveData.AlgebraMove != "Nc6" {
+		t.Errorf("got %+v, want post line 102", moveData)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("search took %s, want it to stop at the stopId line", elapsed)
+	}
+	if slot(p) != nil {
+		t.Error("an engine still thinking was kept")
+	}
+}
+
+func TestPoolRecyclesAfterMaxMoves(t *testing.T) {
+	p := newTestPool(t, PoolConfig{MaxMoves: 2}, "")
+	settings := Settings{ClockTime: 100}
+
+	if _, err := p.GetMove(context.Background(), settings); err != nil {
+		t.Fatal(err)
+	}
+	first := slot(p)
+	if first == nil || first.moves != 1 {
+		t.Fatalf("after one move got %+v, want the engine kept with 1 move", first)
+	}
+
+	if _, err := p.GetMove(context.Background(), settings); err != nil {
+		t.Fatal(err)
+	}
+	if slot(p) != nil {
+		t.Fatal("engine was kept after MaxMoves moves")
+	}
+
+	moveData, err := p.GetMove(context.Background(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moveData.CoordinateMove == "" {
+		t.Errorf("new engine got %+v, want a move", moveData)
+	}
+	if next := slot(p); next == nil || next == first {
+		t.Error("the recycled engine was not replaced by a new one")
+	}
+}
+
+func TestPoolConcurrentGetMove(t *testing.T) {
+	p := newTestPool(t, PoolConfig{Size: 4, Verbose: true}, "")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			moveData, err := p.GetMove(context.Background(), Settings{Moves: []string{"e2e4"}, ClockTime: 100})
+			if err == nil && moveData.CoordinateMove == "" {
+				err = fmt.Errorf("got %+v, want a move", moveData)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestGetMoveAfterClose(t *testing.T) {
+	p := newTestPool(t, PoolConfig{}, "")
+	p.Close()
+
+	_, err := p.GetMove(context.Background(), Settings{ClockTime: 100})
+	if !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("got %v, want ErrPoolClosed", err)
+	}
+	if code := moveErrCode(t, err); code != models.ErrEngineUnavailable {
+		t.Errorf("code got %s, want %s", code, models.ErrEngineUnavailable)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package engine
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessTree only reaches the direct child on windows, where the King
+// runs without wine in between.
+func killProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
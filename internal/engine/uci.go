@@ -0,0 +1,340 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	chess "github.com/corentings/chess/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/thinktt/yowking/internal/books"
+	"github.com/thinktt/yowking/pkg/models"
+)
+
+// uciHandshakeTimeout bounds the uci/uciok and isready/readyok exchanges.
+const uciHandshakeTimeout = 10 * time.Second
+
+// uciStopGrace is how long a UCI engine gets to answer stop with bestmove.
+const uciStopGrace = time.Second
+
+// mateEval stands in for a centipawn eval when a UCI engine reports mate.
+const mateEval = 30000
+
+// uciHandshake sends uci and records each option's default so options a
+// previous personality set can be put back.
+func (p *process) uciHandshake() error {
+	p.uciDefaults = map[string]string{}
+	p.uciSet = map[string]string{}
+	p.write("uci\n")
+
+	timer := time.NewTimer(uciHandshakeTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return errors.New("uci engine exited during handshake")
+			}
+			if line == "uciok" {
+				return nil
+			}
+			if name, def, ok := parseUciOption(line); ok {
+				p.uciDefaults[name] = def
+			}
+		case <-timer.C:
+			return errors.New("uci engine never sent uciok")
+		}
+	}
+}
+
+// parseUciOption pulls the name and default out of an option line such as
+// "option name Skill Level type spin default 20 min 0 max 20".
+func parseUciOption(line string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(line, "option name ")
+	if !ok {
+		return "", "", false
+	}
+	name, rest, ok := strings.Cut(rest, " type ")
+	if !ok {
+		return "", "", false
+	}
+	def := ""
+	if _, after, ok := strings.Cut(rest, "default "); ok {
+		def = after
+		for _, keyword := range []string{" min ", " max ", " var "} {
+			def, _, _ = strings.Cut(def, keyword)
+		}
+	}
+	return name, strings.TrimSpace(def), true
+}
+
+// waitReady sends isready and waits for readyok, discarding anything else.
+func (p *process) waitReady(ctx context.Context) error {
+	p.write("isready\n")
+	timer := time.NewTimer(uciHandshakeTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				return errors.New("uci engine exited")
+			}
+			if line == "readyok" {
+				return nil
+			}
+		case <-timer.C:
+			return errors.New("uci engine never sent readyok")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// searchUci is search for UCI engines: it applies the personality's options,
+// sends the position and reads info lines into the same MoveData the King's
+// post lines produce.
+func (p *process) searchUci(ctx context.Context, settings Settings, log *logrus.Entry) (moveData MoveData, reusable bool, err error) {
+	if settings.RandomIsOff {
+		log.Info("randomIsOff has no UCI equivalent, ignoring it")
+	}
+
+	p.write("ucinewgame\n")
+	for _, option := range p.uciOptionChanges(settings.Uci.Options) {
+		p.write(option)
+	}
+	if err := p.waitReady(ctx); err != nil {
+		return MoveData{}, false, err
+	}
+
+	p.write(uciPosition(settings.StartFen, settings.Moves))
+	if settings.Uci.MoveTime > 0 {
+		p.write(fmt.Sprintf("go movetime %d\n", settings.Uci.MoveTime))
+	} else {
+		// the King's clock is in centiseconds, UCI wants ms
+		clockMs := settings.ClockTime * 10
+		p.write(fmt.Sprintf("go wtime %d btime %d\n", clockMs, clockMs))
+	}
+
+	timeout := MoveTimeout(settings.ClockTime)
+	if settings.Uci.MoveTime > 0 {
+		timeout = time.Duration(settings.Uci.MoveTime)*time.Millisecond + timeoutGrace
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		moveData MoveData
+		idle     bool
+	}
+	resultChan := make(chan result, 1)
+	best := &searchState{think: thinkingFrom(ctx)}
+	defer best.stopThinking()
+	go func() {
+		moveData, idle := readUciOut(p.lines, best, settings.StartFen, settings.Moves, p.verbose, log)
+		resultChan <- result{moveData, idle}
+	}()
+
+	select {
+	case res := <-resultChan:
+		return res.moveData, res.idle && res.moveData.Err == nil, nil
+	case <-ctx.Done():
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		searchTimeouts.Add(1)
+	}
+
+	// unlike the King a UCI engine can be asked to stop and still answer
+	wantMove := stopped(ctx) || errors.Is(ctx.Err(), context.DeadlineExceeded)
+	p.write("stop\n")
+	select {
+	case res := <-resultChan:
+		if res.moveData.Err == nil && wantMove {
+			if !stopped(ctx) {
+				log.Errorf("uci engine gave no move within %s, took its move after stop", timeout)
+			}
+			return res.moveData, res.idle, nil
+		}
+	case <-time.After(uciStopGrace):
+	}
+
+	if !wantMove {
+		log.Println("move request canceled, killing engine")
+		return MoveData{}, false, ctx.Err()
+	}
+
+	moveData = best.latest()
+	if stopped(ctx) {
+		log.Error("uci engine ignored stop, killing engine")
+		if moveData.CoordinateMove == "" {
+			return MoveData{}, false, ErrStopped
+		}
+		return moveData, false, nil
+	}
+	log.Errorf("uci engine ignored stop after %s, killing engine", timeout)
+	if moveData.CoordinateMove == "" {
+		return MoveData{}, false, &TimeoutError{ClockTime: settings.ClockTime, Timeout: timeout}
+	}
+	log.Println("using best info line move:", moveData.AlgebraMove)
+	return moveData, false, nil
+}
+
+// uciOptionChanges returns the setoption commands that take the engine from
+// the options it has to the wanted ones, restoring defaults for options the
+// last personality set and this one doesn't.
+func (p *process) uciOptionChanges(wanted map[string]string) []string {
+	names := make([]string, 0, len(wanted)+len(p.uciSet))
+	for name := range wanted {
+		names = append(names, name)
+	}
+	for name := range p.uciSet {
+		if _, ok := wanted[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	commands := make([]string, 0, len(names))
+	for _, name := range names {
+		value, ok := wanted[name]
+		if !ok {
+			value = p.uciDefaults[name]
+		}
+		if p.uciSet[name] == value {
+			continue
+		}
+		commands = append(commands, fmt.Sprintf("setoption name %s value %s\n", name, value))
+		if ok {
+			p.uciSet[name] = value
+		} else {
+			delete(p.uciSet, name)
+		}
+	}
+	return commands
+}
+
+func uciPosition(startFen string, moves []string) string {
+	position := "position startpos"
+	if startFen != "" {
+		position = "position fen " + startFen
+	}
+	if len(moves) == 0 {
+		return position + "\n"
+	}
+	return fmt.Sprintf("%s moves %s\n", position, strings.Join(moves, " "))
+}
+
+// readUciOut consumes UCI output until bestmove. Each info line with a pv
+// becomes the search's current best, with its first pv move in SAN so
+// callers see the same fields as for the King.
+func readUciOut(lines <-chan string, best *searchState, startFen string, moves []string, verbose bool, log *logrus.Entry) (MoveData, bool) {
+	pos := positionAfter(startFen, moves)
+
+	for engineLine := range lines {
+		if verbose {
+			log.Println(engineLine)
+		}
+
+		words := strings.Fields(engineLine)
+		if len(words) == 0 {
+			continue
+		}
+
+		switch words[0] {
+		case "bestmove":
+			if len(words) < 2 || words[1] == "(none)" {
+				return calloutMoveData(models.ErrGameOver, engineLine), true
+			}
+			moveData := best.latest()
+			if moveData.CoordinateMove != words[1] {
+				moveData.CoordinateMove = words[1]
+				moveData.AlgebraMove = coordinateToSan(pos, words[1])
+			}
+			return moveData, true
+		case "info":
+			moveData, pv, err := parseInfoLine(words)
+			if err != nil {
+				continue
+			}
+			moveData.CoordinateMove = pv
+			moveData.AlgebraMove = coordinateToSan(pos, pv)
+			best.set(moveData)
+		}
+	}
+
+	return models.ErrMoveData(models.NewMoveError(models.ErrEngineCrash, "engine exited before sending a move")), false
+}
+
+// parseInfoLine reads depth, score, time and the first pv move from an info
+// line. Time is converted to centiseconds to match the King's post lines.
+func parseInfoLine(words []string) (MoveData, string, error) {
+	moveData := MoveData{}
+	pv := ""
+	hasScore := false
+
+	for i := 1; i < len(words); i++ {
+		next := ""
+		if i+1 < len(words) {
+			next = words[i+1]
+		}
+		switch words[i] {
+		case "depth":
+			moveData.Depth, _ = strconv.Atoi(next)
+			i++
+		case "time":
+			ms, _ := strconv.Atoi(next)
+			moveData.Time = ms / 10
+			i++
+		case "score":
+			if i+2 >= len(words) {
+				return MoveData{}, "", errors.New("truncated score")
+			}
+			n, err := strconv.Atoi(words[i+2])
+			if err != nil {
+				return MoveData{}, "", err
+			}
+			if next == "mate" {
+				if n > 0 {
+					n = mateEval - n
+				} else {
+					n = -mateEval - n
+				}
+			}
+			moveData.Eval = n
+			hasScore = true
+			i += 2
+		case "pv":
+			pv = next
+			i = len(words)
+		}
+	}
+
+	if pv == "" || !hasScore {
+		return MoveData{}, "", errors.New("info line has no score and pv")
+	}
+	return moveData, pv, nil
+}
+
+func positionAfter(startFen string, moves []string) *chess.Position {
+	g, err := books.PlayMoves(startFen, moves)
+	if err != nil {
+		return nil
+	}
+	return g.Position()
+}
+
+// coordinateToSan renders a coordinate move in SAN, or returns it unchanged
+// if the position is unknown or the move doesn't fit it.
+func coordinateToSan(pos *chess.Position, move string) string {
+	if pos == nil {
+		return move
+	}
+	m, err := chess.UCINotation{}.Decode(pos, move)
+	if err != nil {
+		return move
+	}
+	return chess.AlgebraicNotation{}.Encode(pos, m)
+}
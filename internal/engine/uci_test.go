@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thinktt/yowking/pkg/models"
+)
+
+func TestUciMateScores(t *testing.T) {
+	tests := []struct {
+		score string
+		eval  int
+	}{
+		{"cp 35", 35},
+		{"mate 3", mateEval - 3},
+		{"mate -2", -mateEval + 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.score, func(t *testing.T) {
+			p := newTestPool(t, PoolConfig{Protocol: ProtocolUci},
+				"on go\npost depth 5 score "+tt.score+" time 120 pv d1h5 b8c6\nmove d1h5\n")
+
+			moveData, err := p.GetMove(context.Background(), Settings{Moves: []string{"e2e4", "e7e5"}, ClockTime: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := MoveData{Depth: 5, Eval: tt.eval, Time: 12, AlgebraMove: "Qh5", CoordinateMove: "d1h5"}
+			if moveData != want {
+				t.Errorf("got %+v, want %+v", moveData, want)
+			}
+		})
+	}
+}
+
+func TestUciBestmoveNone(t *testing.T) {
+	p := newTestPool(t, PoolConfig{Protocol: ProtocolUci}, "")
+
+	// white is mated, fakeking has no move to give
+	settings := Settings{StartFen: "rnb1kbnr/pppp1ppp/8/4p3/6Pq/5P2/PPPPP2P/RNBQKBNR w KQkq - 1 3", ClockTime: 100}
+	moveData, err := p.GetMove(context.Background(), settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moveData.Error == nil || moveData.Error.Code != models.ErrGameOver {
+		t.Errorf("got %+v, want a %s error", moveData, models.ErrGameOver)
+	}
+}
+
+func TestUciRestoresOptionDefaults(t *testing.T) {
+	p := newTestPool(t, PoolConfig{Protocol: ProtocolUci}, "")
+
+	first := Settings{ClockTime: 100, Uci: models.UciSettings{Options: map[string]string{"Skill Level": "5"}}}
+	if _, err := p.GetMove(context.Background(), first); err != nil {
+		t.Fatal(err)
+	}
+	proc := slot(p)
+	wantDefaults := map[string]string{"Skill Level": "20", "UCI_Elo": "1350"}
+	if !reflect.DeepEqual(proc.uciDefaults, wantDefaults) {
+		t.Fatalf("defaults got %v, want %v", proc.uciDefaults, wantDefaults)
+	}
+
+	// the next personality only sets UCI_Elo, so Skill Level goes back to 20
+	second := map[string]string{"UCI_Elo": "1500"}
+	check := &process{uciDefaults: proc.uciDefaults, uciSet: map[string]string{"Skill Level": "5"}}
+	wantCommands := []string{
+		"setoption name Skill Level value 20\n",
+		"setoption name UCI_Elo value 1500\n",
+	}
+	if commands := check.uciOptionChanges(second); !reflect.DeepEqual(commands, wantCommands) {
+		t.Errorf("commands got %q, want %q", commands, wantCommands)
+	}
+
+	if _, err := p.GetMove(context.Background(), Settings{ClockTime: 100, Uci: models.UciSettings{Options: second}}); err != nil {
+		t.Fatal(err)
+	}
+	if proc := slot(p); !reflect.DeepEqual(proc.uciSet, second) {
+		t.Errorf("options set got %v, want %v", proc.uciSet, second)
+	}
+}
+
+func TestUciStopAfterTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   MoveData
+		kept   bool
+	}{
+		{
+			name:   "answers stop",
+			script: "on go\npost depth 3 score cp 20 time 50 pv e7e5\non stop\nmove c7c5\n",
+			want:   MoveData{Depth: 3, Eval: 20, Time: 5, AlgebraMove: "c5", CoordinateMove: "c7c5"},
+			kept:   true,
+		},
+		{
+			name:   "ignores stop",
+			script: "on go\npost depth 3 score cp 20 time 50 pv e7e5\nhang\n",
+			want:   MoveData{Depth: 3, Eval: 20, Time: 5, AlgebraMove: "e5", CoordinateMove: "e7e5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPool(t, PoolConfig{Protocol: ProtocolUci}, tt.script)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			timeouts := Timeouts()
+			moveData, err := p.GetMove(ctx, Settings{Moves: []string{"e2e4"}, ClockTime: 100})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Timeouts() - timeouts; got != 1 {
+				t.Errorf("counted %d timeouts, want 1", got)
+			}
+			if moveData != tt.want {
+				t.Errorf("got %+v, want %+v", moveData, tt.want)
+			}
+			if kept := slot(p) != nil; kept != tt.kept {
+				t.Errorf("engine kept got %v, want %v", kept, tt.kept)
+			}
+		})
+	}
+}
+
+func TestUciStopped(t *testing.T) {
+	p := newTestPool(t, PoolConfig{Protocol: ProtocolUci},
+		"on go\npost depth 3 score cp 20 time 50 pv e7e5\non stop\nmove c7c5\n")
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(300*time.Millisecond, func() { cancel(ErrStopped) })
+	timeouts := Timeouts()
+	moveData, err := p.GetMove(ctx, Settings{Moves: []string{"e2e4"}, ClockTime: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moveData.CoordinateMove != "c7c5" {
+		t.Errorf("got %+v, want the move sent after stop", moveData)
+	}
+	if Timeouts() != timeouts {
+		t.Error("a stopped search was counted as a timeout")
+	}
+	if slot(p) == nil {
+		t.Error("an engine that answered stop was not kept")
+	}
+}
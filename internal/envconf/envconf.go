@@ -0,0 +1,38 @@
+// Package envconf reads the numbers and durations kingworker and kinghttp
+// are tuned with from the environment.
+package envconf
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Positive reads a positive number from the environment, or def when the
+// variable isn't set.
+func Positive(name string, def uint64) (uint64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("%s must be a positive number, got %q", name, value)
+	}
+	return n, nil
+}
+
+// Duration reads a duration such as 20s from the environment, or def when
+// the variable isn't set.
+func Duration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a duration like 20s, got %q", name, value)
+	}
+	return d, nil
+}
@@ -0,0 +1,158 @@
+// Package metrics counts what the move path does and renders it in the
+// Prometheus text format, without pulling in the Prometheus client.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thinktt/yowking/internal/engine"
+)
+
+// durationBuckets are the move latency histogram bounds in seconds, from a
+// book lookup to a GM think on a slow host.
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Metrics is safe for concurrent use.
+type Metrics struct {
+	mu             sync.Mutex
+	moveDurations  map[[2]string]*histogram
+	moveErrors     map[string]uint64
+	redeliveries   uint64
+	inFlight       int64
+	lastEngineMove time.Time
+}
+
+func New() *Metrics {
+	return &Metrics{
+		moveDurations: map[[2]string]*histogram{},
+		moveErrors:    map[string]uint64{},
+	}
+}
+
+// ObserveMove records how long a move request took. moveType is the
+// MoveData type, book or engine, or error when no move was made.
+func (m *Metrics) ObserveMove(moveType, personality string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{moveType, personality}
+	h, ok := m.moveDurations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.moveDurations[key] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+
+	if moveType == "engine" {
+		m.lastEngineMove = time.Now()
+	}
+}
+
+// MoveError counts a failed move request by its models.MoveError code.
+func (m *Metrics) MoveError(code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.moveErrors[code]++
+}
+
+// Redelivery counts a request seen again after an earlier attempt.
+func (m *Metrics) Redelivery() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.redeliveries++
+}
+
+// InFlight adds delta to the number of requests being worked on.
+func (m *Metrics) InFlight(delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight += delta
+}
+
+// LastEngineMove is when the engine last made a move, zero if it hasn't.
+func (m *Metrics) LastEngineMove() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastEngineMove
+}
+
+// WriteTo renders every metric in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP yowking_move_duration_seconds Time taken to answer a move request.\n")
+	b.WriteString("# TYPE yowking_move_duration_seconds histogram\n")
+	keys := make([][2]string, 0, len(m.moveDurations))
+	for key := range m.moveDurations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		h := m.moveDurations[key]
+		labels := fmt.Sprintf(`type="%s",personality="%s"`, escape(key[0]), escape(key[1]))
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(&b, "yowking_move_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, h.counts[i])
+		}
+		fmt.Fprintf(&b, "yowking_move_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&b, "yowking_move_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(&b, "yowking_move_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	b.WriteString("# HELP yowking_move_errors_total Move requests answered with an error, by code.\n")
+	b.WriteString("# TYPE yowking_move_errors_total counter\n")
+	codes := make([]string, 0, len(m.moveErrors))
+	for code := range m.moveErrors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(&b, "yowking_move_errors_total{code=\"%s\"} %d\n", escape(code), m.moveErrors[code])
+	}
+
+	b.WriteString("# HELP yowking_engine_timeouts_total Engine searches that ran past their deadline, with or without a move from their post lines.\n")
+	b.WriteString("# TYPE yowking_engine_timeouts_total counter\n")
+	fmt.Fprintf(&b, "yowking_engine_timeouts_total %d\n", engine.Timeouts())
+
+	b.WriteString("# HELP yowking_redeliveries_total Move requests delivered again after an earlier attempt.\n")
+	b.WriteString("# TYPE yowking_redeliveries_total counter\n")
+	fmt.Fprintf(&b, "yowking_redeliveries_total %d\n", m.redeliveries)
+
+	b.WriteString("# HELP yowking_moves_in_flight Move requests being worked on.\n")
+	b.WriteString("# TYPE yowking_moves_in_flight gauge\n")
+	fmt.Fprintf(&b, "yowking_moves_in_flight %d\n", m.inFlight)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -1,7 +1,8 @@
 package moves
 
 import (
-	"fmt"
+	"context"
+	"errors"
 
 	"github.com/sirupsen/logrus"
 	"github.com/thinktt/yowking/internal/books"
@@ -10,35 +11,71 @@ import (
 	"github.com/thinktt/yowking/pkg/personalities"
 )
 
+var moveEngine engine.Engine
+
+// SetEngine replaces the engine used for non-book moves, nil restores the
+// default King pool.
+func SetEngine(e engine.Engine) {
+	moveEngine = e
+}
+
+// Start loads the default books and starts the default engines with a
+// process for each of the concurrency requests worked on at once, so a bad
+// ENG_CMD or ENG_CONFIG fails at startup rather than on the first move.
+func Start(concurrency int) {
+	engine.EnsurePoolSize(concurrency)
+	engine.Default()
+	books.Default()
+}
+
+func currentEngine() engine.Engine {
+	if moveEngine == nil {
+		return engine.Default()
+	}
+	return moveEngine
+}
+
 // HandleMoveReq resolves a move request via book lookup first, then engine fallback.
-func HandleMoveReq(moveReq models.MoveReq) (models.MoveData, error) {
+// ctx bounds the engine search; see engine.GetMove. A failed request is
+// reported in MoveData.Error (and the older MoveData.Err string); err is only
+// set when ctx ends before a move is found.
+func HandleMoveReq(ctx context.Context, moveReq models.MoveReq) (models.MoveData, error) {
 	logContext := logrus.WithFields(logrus.Fields{
 		"gameId": moveReq.GameId,
 		"moveNo": len(moveReq.Moves),
 	})
 
-	cmp, ok := personalities.CmpMap[moveReq.CmpName]
-	if !ok {
-		errMsg := fmt.Sprintf("%s is not a valid personality", moveReq.CmpName)
-		logContext.Error(errMsg)
-		return models.MoveData{Err: &errMsg}, nil
+	if moveErr := ValidateMoveReq(moveReq); moveErr != nil {
+		logContext.Error("rejecting move request: ", moveErr)
+		moveData := models.ErrMoveData(moveErr)
+		moveData.GameId = moveReq.GameId
+		return moveData, nil
 	}
+
+	cmp := personalities.CmpMap[moveReq.CmpName]
 	logContext.Println("playing as", cmp.Name, "using book", cmp.Book)
 
 	if moveReq.ShouldSkipBook {
 		logContext.Println("shouldSkipBook is set, skipping book check")
 	} else {
-		bookMove, err := books.GetMove(moveReq.Moves, cmp.Book)
-		if err == nil {
+		bookMove, err := books.GetMove(moveReq.StartFen, moveReq.Moves, cmp.Book)
+		switch {
+		case err == nil:
 			bookMove.GameId = moveReq.GameId
 			logContext.Println("book move found:", bookMove.CoordinateMove)
 			return bookMove, nil
+		case errors.Is(err, books.ErrNoBookMove):
+			logContext.Println("no book move found, sending move to engine")
+		default:
+			// the engine can still play without the book
+			logContext.Error("book lookup failed, sending move to engine: ", err)
 		}
-		logContext.Println("no book move found, sending move to engine")
 	}
 
 	settings := moveReq
 	settings.CmpVals = cmp.Vals
+	settings.Engine = cmp.Engine
+	settings.Uci = cmp.Uci
 	if moveReq.ClockTime == 0 {
 		settings.ClockTime = personalities.GetClockTime(cmp)
 		logContext.Println("using calibrated clock time:", settings.ClockTime)
@@ -46,14 +83,24 @@ func HandleMoveReq(moveReq models.MoveReq) (models.MoveData, error) {
 		logContext.Println("using manual clock time:", settings.ClockTime)
 	}
 
-	moveData, err := engine.GetMove(settings)
+	moveData, err := currentEngine().GetMove(ctx, settings)
 	if err != nil {
 		logContext.Error("There was ane error getting the move: ", err)
-		return models.MoveData{}, err
+		if ctx.Err() != nil {
+			return models.MoveData{}, err
+		}
+		var moveErr *models.MoveError
+		if !errors.As(err, &moveErr) {
+			moveErr = models.WrapMoveError(models.ErrEngineCrash, err)
+		}
+		moveData = models.ErrMoveData(moveErr)
+		moveData.GameId = moveReq.GameId
+		return moveData, nil
 	}
 
 	if moveData.Err != nil {
 		logContext.Error(*moveData.Err)
+		moveData.GameId = moveReq.GameId
 		return moveData, nil
 	}
 
@@ -0,0 +1,95 @@
+package moves
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	chess "github.com/corentings/chess/v2"
+	"github.com/thinktt/yowking/internal/books"
+	"github.com/thinktt/yowking/pkg/models"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+var (
+	uciMovePattern = regexp.MustCompile(`^[a-h][1-8][a-h][1-8][qrbn]?$`)
+	idPattern      = regexp.MustCompile(`^[A-Za-z0-9]{1,15}$`)
+)
+
+// maxStartFenLen matches the binding tag on MoveReq.StartFen.
+const maxStartFenLen = 100
+
+// ValidateMoveReq checks a move request before any book or engine sees it:
+// the field constraints from the MoveReq binding tags, a known personality,
+// and a replay of the moves that must be legal UCI moves leading to a game
+// that isn't over. It returns nil when the request can be played.
+func ValidateMoveReq(moveReq models.MoveReq) *models.MoveError {
+	if !idPattern.MatchString(moveReq.CmpName) {
+		return badRequest("cmpName must be 1 to 15 letters or digits, got %q", moveReq.CmpName)
+	}
+	if !idPattern.MatchString(moveReq.GameId) {
+		return badRequest("gameId must be 1 to 15 letters or digits, got %q", moveReq.GameId)
+	}
+	if moveReq.StopId < 0 {
+		return badRequest("stopId can't be negative")
+	}
+	if moveReq.ClockTime < 0 {
+		return badRequest("clockTime can't be negative")
+	}
+	if len(moveReq.StartFen) > maxStartFenLen {
+		return badRequest("startFen is longer than %d characters", maxStartFenLen)
+	}
+
+	if _, ok := personalities.CmpMap[moveReq.CmpName]; !ok {
+		return models.NewMoveError(models.ErrUnknownPersonality,
+			fmt.Sprintf("%s is not a valid personality", moveReq.CmpName))
+	}
+
+	g, err := books.NewGame(moveReq.StartFen)
+	if err != nil {
+		return badRequest("startFen %q: %v", moveReq.StartFen, err)
+	}
+
+	for i, move := range moveReq.Moves {
+		if !uciMovePattern.MatchString(move) {
+			return models.NewMoveError(models.ErrIllegalMove,
+				fmt.Sprintf("move %d (%q) is not a UCI move", i+1, move))
+		}
+		if g.Outcome() != chess.NoOutcome {
+			return gameOver(g)
+		}
+		if err := g.PushNotationMove(move, chess.UCINotation{}, nil); err != nil {
+			return models.NewMoveError(models.ErrIllegalMove,
+				fmt.Sprintf("move %d (%q) is illegal", i+1, move))
+		}
+	}
+
+	if g.Outcome() != chess.NoOutcome {
+		return gameOver(g)
+	}
+	// the King never claims these draws, but a game that could be claimed
+	// is treated as over
+	for _, method := range []chess.Method{chess.ThreefoldRepetition, chess.FiftyMoveRule} {
+		if slices.Contains(g.EligibleDraws(), method) {
+			return models.NewMoveError(models.ErrGameOver,
+				fmt.Sprintf("game is drawn by %s", method))
+		}
+	}
+
+	return nil
+}
+
+func badRequest(format string, args ...any) *models.MoveError {
+	return models.NewMoveError(models.ErrBadRequest, fmt.Sprintf(format, args...))
+}
+
+func gameOver(g *chess.Game) *models.MoveError {
+	return models.NewMoveError(models.ErrGameOver,
+		fmt.Sprintf("game is over: %s by %s", g.Outcome(), g.Method()))
+}
+
+// ValidGameId reports whether id is a usable gameId, and so can be the last
+// token of a move-res subject.
+func ValidGameId(id string) bool {
+	return idPattern.MatchString(id)
+}
@@ -0,0 +1,53 @@
+package moves
+
+import (
+	"testing"
+
+	"github.com/thinktt/yowking/pkg/models"
+	"github.com/thinktt/yowking/pkg/personalities"
+)
+
+func TestValidateMoveReq(t *testing.T) {
+	personalities.CmpMap["Tester"] = models.Cmp{}
+	t.Cleanup(func() { delete(personalities.CmpMap, "Tester") })
+
+	foolsMate := []string{"f2f3", "e7e5", "g2g4", "d8h4"}
+	knightsOut := []string{"g1f3", "g8f6", "f3g1", "f6g8"}
+	tests := []struct {
+		name string
+		req  models.MoveReq
+		code string
+	}{
+		{"playable", models.MoveReq{CmpName: "Tester", GameId: "game1", Moves: []string{"e2e4"}}, ""},
+		{"unknown personality", models.MoveReq{CmpName: "Nobody", GameId: "game1"}, models.ErrUnknownPersonality},
+		{"illegal move", models.MoveReq{CmpName: "Tester", GameId: "game1", Moves: []string{"e2e5"}}, models.ErrIllegalMove},
+		{"not uci", models.MoveReq{CmpName: "Tester", GameId: "game1", Moves: []string{"e4"}}, models.ErrIllegalMove},
+		{"bad fen", models.MoveReq{CmpName: "Tester", GameId: "game1", StartFen: "8/8/8 w - - 0 1"}, models.ErrBadRequest},
+		{"bad gameId", models.MoveReq{CmpName: "Tester", GameId: "game-1"}, models.ErrBadRequest},
+		{"empty gameId", models.MoveReq{CmpName: "Tester"}, models.ErrBadRequest},
+		{"checkmate", models.MoveReq{CmpName: "Tester", GameId: "game1", Moves: foolsMate}, models.ErrGameOver},
+		{"move after mate", models.MoveReq{CmpName: "Tester", GameId: "game1", Moves: append(foolsMate, "a2a3")}, models.ErrGameOver},
+		{
+			"threefold repetition",
+			models.MoveReq{CmpName: "Tester", GameId: "game1", Moves: append(append([]string(nil), knightsOut...), knightsOut...)},
+			models.ErrGameOver,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moveErr := ValidateMoveReq(tt.req)
+			if tt.code == "" {
+				if moveErr != nil {
+					t.Fatalf("got %v, want the request accepted", moveErr)
+				}
+				return
+			}
+			if moveErr == nil {
+				t.Fatalf("request was accepted, want a %s error", tt.code)
+			}
+			if moveErr.Code != tt.code || moveErr.Retryable {
+				t.Errorf("got %s retryable %v, want %s that isn't retryable", moveErr.Code, moveErr.Retryable, tt.code)
+			}
+		})
+	}
+}
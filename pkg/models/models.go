@@ -2,14 +2,32 @@ package models
 
 // MoveReq is the worker request contract used by kingworker.
 type MoveReq struct {
-	Moves          []string `json:"moves" binding:"required,dive,alphanum,min=4,max=5"`
-	CmpName        string   `json:"cmpName" binding:"required,alphanum,max=15"`
-	GameId         string   `json:"gameId" binding:"required,alphanum,max=15"`
-	StopId         int      `json:"stopId" binding:"omitempty,alphanum,max=15"`
-	ClockTime      int      `json:"clockTime" binding:"omitempty,alphanum,max=15"`
-	RandomIsOff    bool     `json:"randomIsOff"`
-	ShouldSkipBook bool     `json:"shouldSkipBook"`
-	CmpVals        CmpVals  `json:"-"`
+	Moves              []string    `json:"moves" binding:"required,dive,alphanum,min=4,max=5"`
+	StartFen           string      `json:"startFen,omitempty" binding:"omitempty,max=100"`
+	CmpName            string      `json:"cmpName" binding:"required,alphanum,max=15"`
+	GameId             string      `json:"gameId" binding:"required,alphanum,max=15"`
+	StopId             int         `json:"stopId" binding:"omitempty,alphanum,max=15"`
+	ClockTime          int         `json:"clockTime" binding:"omitempty,alphanum,max=15"`
+	RandomIsOff        bool        `json:"randomIsOff"`
+	ShouldSkipBook     bool        `json:"shouldSkipBook"`
+	ShouldPostThinking bool        `json:"shouldPostThinking,omitempty"`
+	CmpVals            CmpVals     `json:"-"`
+	Engine             string      `json:"-"`
+	Uci                UciSettings `json:"-"`
+}
+
+// Engine backends a personality can play through.
+const (
+	EngineKing = "king"
+	EngineUci  = "uci"
+)
+
+// UciSettings tune a UCI engine for personalities that play through one.
+type UciSettings struct {
+	// Options are sent as setoption, e.g. "Skill Level" or "UCI_Elo".
+	Options map[string]string `json:"options,omitempty"`
+	// MoveTime fixes the search to go movetime in ms, 0 searches on the clock.
+	MoveTime int `json:"moveTime,omitempty"`
 }
 
 // CmpVals are the King engine personality tuning parameters.
@@ -50,18 +68,95 @@ type Cmp struct {
 	Ponder string  `json:"ponder"`
 	Book   string  `json:"book"`
 	Rating int     `json:"rating"`
+	// Engine is EngineKing (or empty) for the King, EngineUci for a UCI engine.
+	Engine string      `json:"engine,omitempty"`
+	Uci    UciSettings `json:"uci,omitempty"`
 }
 
 // MoveData is the kingworker response payload.
 type MoveData struct {
-	Depth          int     `json:"depth,omitempty"`
-	Eval           int     `json:"eval,omitempty"`
-	Time           int     `json:"time,omitempty"`
-	Id             int     `json:"id,omitempty"`
-	AlgebraMove    string  `json:"algebraMove,omitempty"`
-	CoordinateMove string  `json:"coordinateMove,omitempty"`
-	WillAcceptDraw bool    `json:"willAcceptDraw"`
-	Err            *string `json:"err,omitempty"`
-	Type           string  `json:"type"`
-	GameId         string  `json:"gameId,omitempty"`
+	Depth          int        `json:"depth,omitempty"`
+	Eval           int        `json:"eval,omitempty"`
+	Time           int        `json:"time,omitempty"`
+	Id             int        `json:"id,omitempty"`
+	AlgebraMove    string     `json:"algebraMove,omitempty"`
+	CoordinateMove string     `json:"coordinateMove,omitempty"`
+	WillAcceptDraw bool       `json:"willAcceptDraw"`
+	Err            *string    `json:"err,omitempty"`
+	Error          *MoveError `json:"error,omitempty"`
+	Type           string     `json:"type"`
+	GameId         string     `json:"gameId,omitempty"`
+}
+
+// ThinkData is one of the engine's post lines, published while it searches
+// for requests with ShouldPostThinking. Ply is the number of moves played
+// before the search, so stale events from an earlier move can be dropped.
+type ThinkData struct {
+	GameId      string `json:"gameId"`
+	CmpName     string `json:"cmpName"`
+	Ply         int    `json:"ply"`
+	Depth       int    `json:"depth"`
+	Eval        int    `json:"eval"`
+	Time        int    `json:"time"`
+	AlgebraMove string `json:"algebraMove"`
+}
+
+// Error codes for failed move requests.
+const (
+	ErrBadRequest         = "BAD_REQUEST"
+	ErrUnknownPersonality = "UNKNOWN_PERSONALITY"
+	ErrIllegalMove        = "ILLEGAL_MOVE"
+	ErrGameOver           = "GAME_OVER"
+	ErrEngineRejected     = "ENGINE_REJECTED"
+	ErrEngineTimeout      = "ENGINE_TIMEOUT"
+	ErrEngineCrash        = "ENGINE_CRASH"
+	ErrEngineUnavailable  = "ENGINE_UNAVAILABLE"
+	ErrBookIO             = "BOOK_IO"
+	ErrCanceled           = "CANCELED"
+)
+
+// retryableCodes are failures of the worker rather than the request, so the
+// same request may succeed if sent again.
+var retryableCodes = map[string]bool{
+	ErrEngineTimeout:     true,
+	ErrEngineCrash:       true,
+	ErrEngineUnavailable: true,
+	ErrBookIO:            true,
+	ErrCanceled:          true,
+}
+
+// MoveError says why no move was made. Code is one of the Err constants and
+// stays stable, Message is for people.
+type MoveError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	cause     error
+}
+
+// NewMoveError builds a MoveError, deriving Retryable from the code.
+func NewMoveError(code, message string) *MoveError {
+	return &MoveError{Code: code, Message: message, Retryable: retryableCodes[code]}
+}
+
+// WrapMoveError gives err a code, keeping it for errors.Is and errors.As.
+func WrapMoveError(code string, err error) *MoveError {
+	moveErr := NewMoveError(code, err.Error())
+	moveErr.cause = err
+	return moveErr
+}
+
+func (e *MoveError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func (e *MoveError) Unwrap() error {
+	return e.cause
+}
+
+// ErrMoveData reports a MoveError, with Err set to the message for clients
+// that only read the string.
+func ErrMoveData(moveErr *MoveError) MoveData {
+	message := moveErr.Message
+	return MoveData{Err: &message, Error: moveErr}
 }
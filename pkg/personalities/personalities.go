@@ -35,29 +35,29 @@ func Reload() {
 func loadCmps() {
 	file, err := os.Open("personalities.json")
 	if err != nil {
-		fmt.Println("Error opening file:", err)
+		fmt.Fprintln(os.Stderr, "Error opening file:", err)
 		return
 	}
 	defer file.Close()
 
 	err = json.NewDecoder(file).Decode(&CmpMap)
 	if err != nil {
-		fmt.Println("Error decoding JSON:", err)
+		fmt.Fprintln(os.Stderr, "Error decoding JSON:", err)
 	}
 }
 
 func loadClockTimes() {
 	clockTimesFile, err := os.Open("calibrations/clockTimes.json")
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 	}
 	defer clockTimesFile.Close()
 	err = json.NewDecoder(clockTimesFile).Decode(&clockTimes)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		return
 	}
-	fmt.Printf("clockTimes loaded: %+v\n", clockTimes)
+	fmt.Fprintf(os.Stderr, "clockTimes loaded: %+v\n", clockTimes)
 }
 
 func GetDrawEval(currentEval int, settings models.MoveReq) bool {
@@ -84,3 +84,8 @@ func GetClockTime(cmp models.Cmp) int {
 
 	return clockTimes.Hard
 }
+
+// MaxClockTime is the longest calibrated clock time any personality gets.
+func MaxClockTime() int {
+	return max(clockTimes.Easy, clockTimes.Hard, clockTimes.Gm)
+}